@@ -1,10 +1,19 @@
 package distributedCache
 
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
 // 实现缓存值的抽象与封装
 
 // ByteView 抽象的数据结构表示缓存值
 type ByteView struct {
-	b []byte // 存储真实的缓存值，选择 byte 类型是为了能够支持任意的数据类型的存储
+	b []byte        // 存储真实的缓存值，选择 byte 类型是为了能够支持任意的数据类型的存储
+	e time.Time     // 缓存值的过期时间，零值表示永不过期
+	s bool          // 是否是已经过期、在 stale 窗口内返回的旧值
+	d time.Duration // 从数据源加载这个值的耗时，用于提前刷新的概率计算
 }
 
 // Len 在 lru.Cache 的实现中，要求被缓存对象必须实现 Value 接口，即 Len() int 方法，返回其所占的内存大小
@@ -23,6 +32,32 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// Expire 返回缓存值的过期时间，零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// Stale 缓存值是否已经过期，开启 WithStaleWhileRevalidate 或 WithStaleIfError 时 Get 可能返回过期的旧值
+func (v ByteView) Stale() bool {
+	return v.s
+}
+
+// expired 缓存值在 now 时刻是否已经过期
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && now.After(v.e)
+}
+
+// refreshEarly XFetch 算法：now - d * beta * ln(rand) 超过过期时间时提前刷新，
+// 越接近过期、加载越慢，提前刷新的概率越大，同一时刻只有少数调用者会选中刷新
+func (v ByteView) refreshEarly(now time.Time, beta float64) bool {
+	if beta <= 0 || v.d <= 0 || v.e.IsZero() {
+		return false
+	}
+	// rand.Float64 可能返回 0，此时 gap 为 +Inf，一定刷新
+	gap := -float64(v.d) * beta * math.Log(rand.Float64())
+	return gap >= float64(v.e.Sub(now))
+}
+
 // cloneBytes
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
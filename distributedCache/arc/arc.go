@@ -0,0 +1,282 @@
+package arc
+
+import (
+	"container/list"
+	"distributedCache/eviction"
+	"time"
+)
+
+// 实现 ARC (Adaptive Replacement Cache) 淘汰策略
+// T1 保存只被访问过一次的节点（最近性），T2 保存被访问过至少两次的节点（频率），
+// B1、B2 是幽灵链表，只记录从 T1、T2 淘汰的 key 和大小，不保存值。
+// 访问命中 B1 说明 T1 太小，调大 T1 的目标大小 p；命中 B2 说明 T2 太小，调小 p。
+// 原始的 ARC 以节点个数计算容量，这里和 lru.CacheLRU 一致，全部以字节计算。
+
+// list 的下标
+const (
+	t1 = iota
+	t2
+	b1
+	b2
+)
+
+// CacheARC ARC 缓存，并发访问是不安全的。
+type CacheARC struct {
+	maxBytes  int64                                  // 允许使用的最大内存，为 0 表示不限制
+	p         int64                                  // T1 的目标大小，随访问模式自适应调整
+	bytes     [4]int64                               // 每个链表的节点大小之和，B1、B2 记录的是被淘汰时的大小
+	lists     [4]*list.List                          // T1、T2、B1、B2，约定 Back 为队尾（最近访问），Front 为队首
+	cacheMap  map[string]*list.Element               // 键是字符串，值是链表中对应节点的指针，包括幽灵节点
+	OnEvicted func(key string, value eviction.Value) // 某条记录被移除时的回调函数，幽灵节点被丢弃时不调用
+}
+
+// node 链表节点的数据类型
+type node struct {
+	key    string
+	value  eviction.Value // 幽灵节点的值为 nil
+	size   int64          // 节点占用的内存
+	expire time.Time      // 过期时间，零值表示永不过期
+	where  int            // 节点所在的链表
+}
+
+// expired 判断节点在 now 时刻是否已经过期
+func (n *node) expired(now time.Time) bool {
+	return !n.expire.IsZero() && now.After(n.expire)
+}
+
+// New 实例化一个 CacheARC
+func New(maxBytes int64, onEvicted func(key string, value eviction.Value)) *CacheARC {
+	c := &CacheARC{
+		maxBytes:  maxBytes,
+		cacheMap:  make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+	for i := range c.lists {
+		c.lists[i] = list.New()
+	}
+	return c
+}
+
+// Find 查找节点，命中后移动到 T2 的队尾，过期的节点视为未命中并顺便删除
+func (c *CacheARC) Find(key string) (value eviction.Value, ok bool) {
+	elem, ok := c.cacheMap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*node)
+	if kv.where == b1 || kv.where == b2 {
+		return nil, false
+	}
+	if kv.expired(time.Now()) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.move(elem, t2)
+	return kv.value, true
+}
+
+// Peek 查找节点，命中后不移动到 T2，幽灵节点和过期的节点视为未命中，过期的节点不删除
+func (c *CacheARC) Peek(key string) (value eviction.Value, ok bool) {
+	elem, ok := c.cacheMap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*node)
+	if kv.where == b1 || kv.where == b2 || kv.expired(time.Now()) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// Add 新增一个永不过期的节点
+func (c *CacheARC) Add(key string, value eviction.Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增一个节点，expire 为零值表示永不过期
+func (c *CacheARC) AddWithExpire(key string, value eviction.Value, expire time.Time) {
+	size := int64(len(key)) + int64(value.Len())
+	elem, ok := c.cacheMap[key]
+	if !ok {
+		// 全新的节点放入 T1
+		kv := &node{key: key, value: value, size: size, expire: expire, where: t1}
+		c.cacheMap[key] = c.lists[t1].PushBack(kv)
+		c.bytes[t1] += size
+		c.replace(false)
+		c.trimGhosts()
+		return
+	}
+	kv := elem.Value.(*node)
+	ghost := kv.where
+	switch ghost {
+	case b1:
+		// 命中 B1，T1 应该更大
+		c.p += c.delta(b2, b1, size)
+		if c.p > c.maxBytes {
+			c.p = c.maxBytes
+		}
+	case b2:
+		// 命中 B2，T2 应该更大
+		c.p -= c.delta(b1, b2, size)
+		if c.p < 0 {
+			c.p = 0
+		}
+	}
+	c.bytes[kv.where] += size - kv.size
+	kv.value, kv.size, kv.expire = value, size, expire
+	c.move(elem, t2)
+	c.replace(ghost == b2)
+	c.trimGhosts()
+}
+
+// delta 命中幽灵链表 hit 时 p 的调整量：另一个幽灵链表 other 越大，调整越多，至少调整一个节点的大小
+func (c *CacheARC) delta(other, hit int, size int64) int64 {
+	if c.bytes[hit] > 0 && c.bytes[other] > c.bytes[hit] {
+		return size * c.bytes[other] / c.bytes[hit]
+	}
+	return size
+}
+
+// move 把节点移动到另一个链表的队尾
+func (c *CacheARC) move(elem *list.Element, where int) {
+	kv := elem.Value.(*node)
+	if kv.where == where {
+		c.lists[where].MoveToBack(elem)
+		return
+	}
+	c.lists[kv.where].Remove(elem)
+	c.bytes[kv.where] -= kv.size
+	kv.where = where
+	c.bytes[where] += kv.size
+	c.cacheMap[kv.key] = c.lists[where].PushBack(kv)
+}
+
+// replace T1 和 T2 超出容量时淘汰节点：T1 超过目标大小 p 时从 T1 淘汰到 B1，否则从 T2 淘汰到 B2
+func (c *CacheARC) replace(hitB2 bool) {
+	for c.maxBytes != 0 && c.bytes[t1]+c.bytes[t2] > c.maxBytes {
+		c.evictOne(hitB2)
+	}
+}
+
+// evictOne 按照 ARC 的规则从 T1 或 T2 淘汰一个节点，并把它的 key 记录到对应的幽灵链表
+func (c *CacheARC) evictOne(hitB2 bool) {
+	from, to := t2, b2
+	if c.lists[t1].Len() > 0 && (c.bytes[t1] > c.p || (hitB2 && c.bytes[t1] == c.p) || c.lists[t2].Len() == 0) {
+		from, to = t1, b1
+	}
+	elem := c.lists[from].Front()
+	if elem == nil {
+		return
+	}
+	kv := elem.Value.(*node)
+	value := kv.value
+	kv.value = nil
+	c.move(elem, to)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, value)
+	}
+}
+
+// trimGhosts 限制幽灵链表的大小：T1+B1 不超过 maxBytes，四个链表之和不超过 2*maxBytes
+func (c *CacheARC) trimGhosts() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.bytes[t1]+c.bytes[b1] > c.maxBytes && c.lists[b1].Len() > 0 {
+		c.dropGhost(c.lists[b1].Front())
+	}
+	for c.bytes[t1]+c.bytes[t2]+c.bytes[b1]+c.bytes[b2] > 2*c.maxBytes && c.lists[b2].Len() > 0 {
+		c.dropGhost(c.lists[b2].Front())
+	}
+}
+
+// dropGhost 丢弃一个幽灵节点
+func (c *CacheARC) dropGhost(elem *list.Element) {
+	kv := elem.Value.(*node)
+	c.lists[kv.where].Remove(elem)
+	c.bytes[kv.where] -= kv.size
+	delete(c.cacheMap, kv.key)
+}
+
+// Remove 按照策略淘汰一个节点
+func (c *CacheARC) Remove() {
+	if c.lists[t1].Len()+c.lists[t2].Len() == 0 {
+		return
+	}
+	c.evictOne(false)
+	c.trimGhosts()
+}
+
+// Delete 删除指定 key 的节点，包括幽灵节点，返回节点是否存在于缓存中
+func (c *CacheARC) Delete(key string) bool {
+	elem, ok := c.cacheMap[key]
+	if !ok {
+		return false
+	}
+	if where := elem.Value.(*node).where; where == b1 || where == b2 {
+		c.dropGhost(elem)
+		return false
+	}
+	c.removeElement(elem)
+	return true
+}
+
+// RemoveExpired 移除所有已经过期的节点，返回移除的数量
+func (c *CacheARC) RemoveExpired() int {
+	now := time.Now()
+	count := 0
+	for _, l := range c.lists[t1 : t2+1] {
+		for elem := l.Front(); elem != nil; {
+			next := elem.Next()
+			if elem.Value.(*node).expired(now) {
+				c.removeElement(elem)
+				count++
+			}
+			elem = next
+		}
+	}
+	return count
+}
+
+// removeElement 删除 T1 或 T2 中的节点，不记录到幽灵链表，并调用回调函数
+func (c *CacheARC) removeElement(elem *list.Element) {
+	kv := elem.Value.(*node)
+	c.lists[kv.where].Remove(elem)
+	c.bytes[kv.where] -= kv.size
+	delete(c.cacheMap, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// GetRecord 用于获取缓存中保存了多少条数据，不包括幽灵节点
+func (c *CacheARC) GetRecord() int {
+	return c.lists[t1].Len() + c.lists[t2].Len()
+}
+
+// Keys 返回 T1 和 T2 中所有没有过期的节点的 key，不包括幽灵节点，不移动节点
+func (c *CacheARC) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, c.GetRecord())
+	for _, l := range c.lists[t1 : t2+1] {
+		for elem := l.Front(); elem != nil; elem = elem.Next() {
+			if kv := elem.Value.(*node); !kv.expired(now) {
+				keys = append(keys, kv.key)
+			}
+		}
+	}
+	return keys
+}
+
+// Bytes 返回当前已使用的内存，不包括幽灵节点
+func (c *CacheARC) Bytes() int64 {
+	return c.bytes[t1] + c.bytes[t2]
+}
+
+// P 返回 T1 当前的目标大小，用于观察 ARC 的自适应过程
+func (c *CacheARC) P() int64 {
+	return c.p
+}
+
+// 检查 CacheARC 是否实现了 eviction.Policy 的全部接口
+var _ eviction.Policy = (*CacheARC)(nil)
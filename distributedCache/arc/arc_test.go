@@ -0,0 +1,124 @@
+package arc
+
+import (
+	"distributedCache/eviction"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestCacheARC_Find(t *testing.T) {
+	c := New(int64(0), nil)
+	c.Add("key1", String("1234"))
+	if v, ok := c.Find("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Find("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestCacheARC_OnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	c := New(int64(10), func(key string, value eviction.Value) {
+		keys = append(keys, key)
+	})
+	c.Add("key1", String("123456"))
+	c.Add("k2", String("k2"))
+	c.Add("k3", String("k3"))
+	c.Add("k4", String("k4"))
+	expect := []string{"key1", "k2"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+	if c.Bytes() > 10 || c.GetRecord() != 2 {
+		t.Fatalf("bytes = %d, records = %d", c.Bytes(), c.GetRecord())
+	}
+}
+
+func TestCacheARC_Expire(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k3", String("v3"), time.Now().Add(time.Hour))
+	if _, ok := c.Find("k1"); ok {
+		t.Fatalf("expired k1 should be a miss")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.GetRecord() != 1 {
+		t.Fatalf("RemoveExpired removed %d, expect 1", n)
+	}
+}
+
+func TestCacheARC_Peek(t *testing.T) {
+	c := New(int64(0), nil)
+	c.Add("k1", String("v1"))
+	// Peek 不把 T1 中的节点移动到 T2
+	if v, ok := c.Peek("k1"); !ok || string(v.(String)) != "v1" {
+		t.Fatalf("peek k1 failed")
+	}
+	if where := c.cacheMap["k1"].Value.(*node).where; where != t1 {
+		t.Fatalf("peek moved k1 to list %d", where)
+	}
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	if _, ok := c.Peek("k2"); ok || c.GetRecord() != 2 {
+		t.Fatalf("peek should miss expired nodes without removing them")
+	}
+}
+
+// access 模拟一次请求，未命中时加入缓存
+func access(c *CacheARC, key string) {
+	if _, ok := c.Find(key); !ok {
+		c.Add(key, String("vvvvvvv"))
+	}
+}
+
+// frequencyPhase 反复访问 6 个热点 key，每个 key 连续访问两次进入 T2
+func frequencyPhase(c *CacheARC) {
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 6; i++ {
+			key := fmt.Sprintf("f%02d", i)
+			access(c, key)
+			access(c, key)
+		}
+	}
+}
+
+// recencyPhase 循环访问一批新的 key，每个 key 在被淘汰后不久又被请求一次
+func recencyPhase(c *CacheARC, phase int) {
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 8; i++ {
+			access(c, fmt.Sprintf("r%d%02d", phase, i))
+		}
+	}
+}
+
+func TestCacheARC_Adapt(t *testing.T) {
+	// 每个节点占 10 字节，缓存可以放下 10 个节点
+	c := New(int64(100), nil)
+	frequencyPhase(c)
+	last := c.P()
+	for i := 0; i < 3; i++ {
+		// 被淘汰到 B1 的 key 很快又被请求，说明 T1 太小，p 应该增大
+		recencyPhase(c, i)
+		if c.P() <= last {
+			t.Fatalf("p should grow under a recency workload, %d -> %d", last, c.P())
+		}
+		last = c.P()
+		// 被淘汰到 B2 的热点 key 又被请求，说明 T2 太小，p 应该减小
+		frequencyPhase(c)
+		if c.P() >= last {
+			t.Fatalf("p should shrink under a frequency workload, %d -> %d", last, c.P())
+		}
+		last = c.P()
+	}
+	if c.Bytes() > 100 {
+		t.Fatalf("bytes %d exceed maxBytes", c.Bytes())
+	}
+}
@@ -0,0 +1,71 @@
+package distributedCache
+
+import (
+	"context"
+	"distributedCache/pb"
+	"math"
+)
+
+// 实现有界负载的一致性哈希（Consistent Hashing with Bounded Loads）：
+// 每个节点的容量是平均负载的 (1+ε) 倍，负载是本节点正在向它发送的 Get 请求数，
+// key 所属的节点已满时顺时针溢出到下一个没有满的节点，热点区间的请求因此分散到相邻的节点上。
+// 溢出的请求带有 forwardedHeader，接收的节点在本地加载并缓存，不会再转发给已满的 owner
+
+// boundedIndex 返回 candidates 中第一个没有满的节点的下标，自己永远不会满，
+// 没有开启有界负载或者所有节点都满时返回 0，调用者需要持有 p.mu 的读锁
+func (p *HTTPPool) boundedIndex(candidates []string) int {
+	if p.loadEpsilon <= 0 {
+		return 0
+	}
+	capacity := p.capacity()
+	for i, addr := range candidates {
+		if addr == p.self || p.httpClients[addr].inFlight.Get() < capacity {
+			if i > 0 {
+				p.spills.Add(1)
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// capacity 每个节点的容量 ceil((1+ε) * (总负载+1) / 远程节点数)，加一是把正在选择节点的这个请求也算上，
+// 本节点不接收发给远程节点的请求，不参与平均
+func (p *HTTPPool) capacity() int64 {
+	var total, n int64
+	for addr, client := range p.httpClients {
+		if addr == p.self {
+			continue
+		}
+		total += client.inFlight.Get()
+		n++
+	}
+	if n == 0 {
+		n = 1
+	}
+	return int64(math.Ceil((1 + p.loadEpsilon) * float64(total+1) / float64(n)))
+}
+
+// spilledGetter key 所属的节点负载已满时 PickPeer 返回的 PeerGetter，读请求发给 read，写请求仍然发给 owner，
+// 保证写入总是落在 key 所属的节点上
+type spilledGetter struct {
+	owner *httpClient
+	read  PeerGetter
+}
+
+// Get 从没有满的节点读取
+func (s *spilledGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return s.read.Get(ctx, in, out)
+}
+
+// Set 写入 key 所属的节点
+func (s *spilledGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	return s.owner.Set(ctx, in)
+}
+
+// Remove 删除 key 所属的节点上的缓存值
+func (s *spilledGetter) Remove(ctx context.Context, in *pb.Request) error {
+	return s.owner.Remove(ctx, in)
+}
+
+var _ PeerGetter = (*spilledGetter)(nil)
@@ -0,0 +1,116 @@
+package distributedCache
+
+import (
+	"context"
+	"distributedCache/pb"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBoundedLoad(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithBoundedLoad(0.25))
+	pool.Set("http://self", "http://a", "http://b")
+	key := keyOwnedBy(pool, "http://a", "http://b")
+	a, b := pool.httpClients["http://a"], pool.httpClients["http://b"]
+
+	// 负载没有超过容量时直接选择 key 所属的节点
+	if peer, _ := pool.PickPeer(key); peer != a {
+		t.Fatalf("expected owner a, got %#v", peer)
+	}
+	// a 有 10 个在途请求，容量按 2 个远程节点计算为 ceil(1.25*11/2)=7，读请求溢出到 b，写请求仍然发给 a
+	a.inFlight.Add(10)
+	if c := pool.capacity(); c != 7 {
+		t.Fatalf("capacity = %d, expect 7", c)
+	}
+	peer, _ := pool.PickPeer(key)
+	spilled, ok := peer.(*spilledGetter)
+	if !ok || spilled.read != b || spilled.owner != a {
+		t.Fatalf("expected spill to b, got %#v", peer)
+	}
+	// b 也有 10 个在途请求，容量为 ceil(1.25*21/2)=14，a 没有满，回到 key 所属的节点
+	b.inFlight.Add(10)
+	if peer, _ = pool.PickPeer(key); peer != a {
+		t.Fatalf("expected owner a, got %#v", peer)
+	}
+	// a 满了，下一个节点是自己时溢出到自己，由本节点加载
+	b.inFlight.Add(-10)
+	local := keyOwnedBy(pool, "http://a", "http://self")
+	peer, _ = pool.PickPeer(local)
+	if spilled, ok := peer.(*spilledGetter); !ok || spilled.read != (localPeer{}) || spilled.owner != a {
+		t.Fatalf("expected spill to self, got %#v", peer)
+	}
+	if stats := pool.Stats(); stats.Spills != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBoundedLoadSpillServedLocally(t *testing.T) {
+	var ownerRequests int32
+	owner := newValueServer("owner", 0, 0, &ownerRequests)
+	defer owner.Close()
+	next, nextPool := newPoolServer()
+	defer next.Close()
+	var loads int32
+	g := NewGroup("boundedSpill", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("next"), nil
+	}))
+	g.RegisterPeers(nextPool)
+	nextPool.Set("http://self", owner.URL, next.URL)
+
+	pool := NewHTTPPool("http://self", WithBoundedLoad(0.25))
+	pool.Set("http://self", owner.URL, next.URL)
+	key := keyOwnedBy(pool, owner.URL, next.URL)
+	get := func() string {
+		peer, _ := pool.PickPeer(key)
+		out := &pb.Response{}
+		if err := peer.Get(context.Background(), &pb.Request{Group: g.name, Key: key}, out); err != nil {
+			t.Fatal(err)
+		}
+		return string(out.Value)
+	}
+	if v := get(); v != "owner" || atomic.LoadInt32(&ownerRequests) != 1 {
+		t.Fatalf("got %q, owner requests = %d", v, ownerRequests)
+	}
+	// owner 已满，溢出的读请求由下一个节点加载并缓存，owner 不再收到请求
+	pool.httpClients[owner.URL].inFlight.Add(10)
+	for i := 0; i < 10; i++ {
+		if v := get(); v != "next" {
+			t.Fatalf("spilled read got %q", v)
+		}
+	}
+	if n := atomic.LoadInt32(&ownerRequests); n != 1 || atomic.LoadInt32(&loads) != 1 {
+		t.Fatalf("owner requests = %d, next loads = %d", n, loads)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	client := &httpClient{baseUrl: server.URL + defaultBasePath}
+
+	done := make(chan struct{})
+	go func() {
+		client.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, &pb.Response{})
+		close(done)
+	}()
+	// 请求进行中时在途请求数为 1，结束后为 0
+	deadline := time.Now().Add(time.Second)
+	for client.inFlight.Get() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("in-flight request not counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+	if n := client.inFlight.Get(); n != 0 {
+		t.Fatalf("in-flight = %d after request finished", n)
+	}
+}
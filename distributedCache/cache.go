@@ -1,41 +1,301 @@
 package distributedCache
 
 import (
+	"distributedCache/arc"
+	"distributedCache/eviction"
 	"distributedCache/lru"
+	"distributedCache/tinyLFU"
 	"sync"
+	"time"
 )
 
-//实现cache的并发控制，实例化 lru，封装 get 和 add 方法，并添加互斥锁 mu
+//实现cache的并发控制，实例化淘汰策略，封装 get 和 add 方法，并为每个分片添加互斥锁 mu
+
+const (
+	defaultSweepInterval = time.Minute // 后台清理过期缓存的默认时间间隔
+	defaultShards        = 16          // 默认的分片数
+	minShardBytes        = 64 << 10    // 每个分片最少分到的缓存空间，避免分片太小导致缓存值频繁被淘汰
+)
+
+// LRUPolicy 使用 lru.CacheLRU 作为淘汰策略，也是默认的淘汰策略
+func LRUPolicy(maxBytes int64, onEvicted func(key string, value eviction.Value)) eviction.Policy {
+	return lru.New(maxBytes, onEvicted)
+}
+
+// TinyLFUPolicy 使用 tinyLFU.CacheTinyLFU 作为淘汰策略，适合存在大量一次性扫描请求的场景
+func TinyLFUPolicy(maxBytes int64, onEvicted func(key string, value eviction.Value)) eviction.Policy {
+	return tinyLFU.New(maxBytes, onEvicted)
+}
+
+// ARCPolicy 使用 arc.CacheARC 作为淘汰策略，根据访问模式在最近性和频率之间自适应
+func ARCPolicy(maxBytes int64, onEvicted func(key string, value eviction.Value)) eviction.Policy {
+	return arc.New(maxBytes, onEvicted)
+}
 
 // 实现并发特性
+// cache 按照 key 的哈希值分成多个分片，每个分片有自己的锁和淘汰策略，缓存空间平均分给各个分片，
+// 这样不同分片上的读写互不阻塞。淘汰策略的 Find 也会修改内部的链表，所以读操作同样需要互斥锁
 type cache struct {
+	shards        []*cacheShard
+	sweepInterval time.Duration // 后台清理过期缓存的时间间隔，为 0 时使用 defaultSweepInterval
+	sweepOnce     sync.Once     // 保证清理协程只启动一次
+	staleFor      time.Duration // 缓存值过期之后继续保留的时间，用于返回过期的旧值
+	disabled      bool          // 不保存任何缓存值，按比例划分的空间为 0 时使用，cacheBytes 为 0 表示的是不限制
+	nget          AtomicInt     // 查找的次数
+	nhit          AtomicInt     // 命中的次数
+	nevict        AtomicInt     // 被淘汰或过期移除的次数
+}
+
+// cacheShard 一个分片
+type cacheShard struct {
 	mu         sync.Mutex
-	lru        *lru.CacheLRU // 采用 LRU 策略
-	cacheBytes int64         // 最大的缓存空间
+	policy     eviction.Policy                        // 淘汰策略
+	newPolicy  eviction.Factory                       // 淘汰策略的构造函数，为 nil 时使用 LRUPolicy
+	onEvicted  func(key string, value eviction.Value) // 节点被移除时的回调函数，用于统计
+	cacheBytes int64                                  // 分片最大的缓存空间
+}
+
+// newCache 创建一个有 shards 个分片的 cache，每个分片至少分到 minShardBytes 的空间，空间不够时减少分片数
+func newCache(cacheBytes int64, shards int, newPolicy eviction.Factory, sweepInterval time.Duration) *cache {
+	if cacheBytes > 0 && int64(shards) > cacheBytes/minShardBytes {
+		shards = int(cacheBytes / minShardBytes)
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	c := &cache{shards: make([]*cacheShard, shards), sweepInterval: sweepInterval}
+	onEvicted := func(key string, value eviction.Value) {
+		c.nevict.Add(1)
+	}
+	for i := range c.shards {
+		// 除不尽的部分分给前面的分片，保证所有分片的空间之和等于 cacheBytes
+		shardBytes := cacheBytes / int64(shards)
+		if int64(i) < cacheBytes%int64(shards) {
+			shardBytes++
+		}
+		c.shards[i] = &cacheShard{cacheBytes: shardBytes, newPolicy: newPolicy, onEvicted: onEvicted}
+	}
+	return c
+}
+
+// shard 根据 key 的哈希值选择分片
+func (c *cache) shard(key string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[fnv32(key)%uint32(len(c.shards))]
+}
+
+// fnv32 计算 key 的 fnv-1a 哈希值，直接展开计算避免每次分配内存
+func fnv32(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// retainUntil 缓存值在淘汰策略中的过期时间，比 ByteView 携带的过期时间多保留 staleFor
+func (c *cache) retainUntil(value ByteView) time.Time {
+	if value.e.IsZero() {
+		return value.e
+	}
+	return value.e.Add(c.staleFor)
 }
 
-// add 封装 LRU 的 Add 方法
+// add 封装淘汰策略的 Add 方法，缓存值的过期时间由 ByteView 携带
 func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if c.disabled {
+		return
+	}
+	c.shard(key).add(key, value, c.retainUntil(value))
+	// 只有出现会过期的缓存值时才需要启动后台清理协程
+	if !value.Expire().IsZero() {
+		c.sweepOnce.Do(func() { go c.sweep() })
+	}
+}
+
+// addIfAbsent 只在 key 不存在或已经过期时写入，返回是否写入，迁移的旧值不会覆盖迁移期间新写入的值
+func (c *cache) addIfAbsent(key string, value ByteView) bool {
+	if c.disabled {
+		return false
+	}
+	if !c.shard(key).addIfAbsent(key, value, c.retainUntil(value)) {
+		return false
+	}
+	if !value.Expire().IsZero() {
+		c.sweepOnce.Do(func() { go c.sweep() })
+	}
+	return true
+}
+
+// find 封装淘汰策略的 Find 方法，过期的缓存值视为未命中
+func (c *cache) find(key string) (value ByteView, ok bool) {
+	c.nget.Add(1)
+	value, ok = c.shard(key).find(key)
+	if ok {
+		c.nhit.Add(1)
+	}
+	return
+}
+
+// peek 封装淘汰策略的 Peek 方法，供节点之间迁移缓存值使用，不计入命中率，也不影响淘汰顺序
+func (c *cache) peek(key string) (value ByteView, ok bool) {
+	return c.shard(key).peek(key)
+}
+
+// remove 封装淘汰策略的 Delete 方法，删除指定 key 的缓存值
+func (c *cache) remove(key string) {
+	// 淘汰策略删除节点时同样会调用回调函数，主动删除不算作淘汰
+	if c.shard(key).remove(key) {
+		c.nevict.Add(-1)
+	}
+}
+
+// keys 返回所有分片中没有过期的 key
+func (c *cache) keys() []string {
+	var keys []string
+	for _, s := range c.shards {
+		keys = append(keys, s.keys()...)
+	}
+	return keys
+}
+
+// removeExpired 清理所有过期的缓存值，让它们不再占用 cacheBytes
+func (c *cache) removeExpired() int {
+	count := 0
+	for _, s := range c.shards {
+		count += s.removeExpired()
+	}
+	return count
+}
+
+// stats 返回缓存的统计信息快照
+func (c *cache) stats() CacheStats {
+	cs := CacheStats{
+		Gets:      c.nget.Get(),
+		Hits:      c.nhit.Get(),
+		Evictions: c.nevict.Get(),
+	}
+	for _, s := range c.shards {
+		bytes, items := s.size()
+		cs.Bytes += bytes
+		cs.Items += items
+	}
+	return cs
+}
+
+// sweep 后台定期清理过期的缓存值，Group 注册后不会被删除，所以清理协程随进程一直运行
+func (c *cache) sweep() {
+	interval := c.sweepInterval
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.removeExpired()
+	}
+}
+
+// add 在分片的锁下调用淘汰策略的 Add 方法
+func (s *cacheShard) add(key string, value ByteView, expire time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(key, value, expire)
+}
+
+// addIfAbsent 在分片的锁下检查 key 是否存在，不存在时写入
+func (s *cacheShard) addIfAbsent(key string, value ByteView, expire time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy != nil {
+		if _, ok := s.policy.Peek(key); ok {
+			return false
+		}
+	}
+	s.addLocked(key, value, expire)
+	return true
+}
+
+// addLocked 调用淘汰策略的 Add 方法，expire 是节点在淘汰策略中的过期时间，调用者需要持有 s.mu
+func (s *cacheShard) addLocked(key string, value ByteView, expire time.Time) {
 	// 延迟初始化(Lazy Initialization) : 意味着该对象的创建将会延迟至第一次使用该对象时
 	// 主要用于提高性能，并减少程序内存要求
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	if s.policy == nil {
+		newPolicy := s.newPolicy
+		if newPolicy == nil {
+			newPolicy = LRUPolicy
+		}
+		s.policy = newPolicy(s.cacheBytes, s.onEvicted)
 	}
-	c.lru.Add(key, value)
+	s.policy.AddWithExpire(key, value, expire)
 }
 
-// find 封装 LRU 的 Find 方法
-func (c *cache) find(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+// find 在分片的锁下调用淘汰策略的 Find 方法
+func (s *cacheShard) find(key string) (value ByteView, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
 		return
 	}
 
-	if v, ok := c.lru.Find(key); ok {
+	if v, ok := s.policy.Find(key); ok {
 		return v.(ByteView), ok
 	}
 	return
 }
+
+// peek 在分片的锁下调用淘汰策略的 Peek 方法，不影响淘汰顺序
+func (s *cacheShard) peek(key string) (value ByteView, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		return
+	}
+	if v, ok := s.policy.Peek(key); ok {
+		return v.(ByteView), ok
+	}
+	return
+}
+
+// remove 在分片的锁下调用淘汰策略的 Delete 方法，返回节点是否存在
+func (s *cacheShard) remove(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		return false
+	}
+	return s.policy.Delete(key)
+}
+
+// keys 在分片的锁下调用淘汰策略的 Keys 方法
+func (s *cacheShard) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		return nil
+	}
+	return s.policy.Keys()
+}
+
+// removeExpired 在分片的锁下调用淘汰策略的 RemoveExpired 方法
+func (s *cacheShard) removeExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		return 0
+	}
+	return s.policy.RemoveExpired()
+}
+
+// size 返回分片已使用的内存和保存的缓存值数量
+func (s *cacheShard) size() (bytes, items int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil {
+		return 0, 0
+	}
+	return s.policy.Bytes(), int64(s.policy.GetRecord())
+}
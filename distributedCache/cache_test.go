@@ -0,0 +1,68 @@
+package distributedCache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCacheShards(t *testing.T) {
+	// 空间足够时使用指定的分片数，所有分片的空间之和等于 cacheBytes
+	c := newCache(16*minShardBytes+3, 16, nil, 0)
+	if len(c.shards) != 16 {
+		t.Fatalf("expect 16 shards, got %d", len(c.shards))
+	}
+	var total int64
+	for _, s := range c.shards {
+		total += s.cacheBytes
+	}
+	if total != 16*minShardBytes+3 {
+		t.Fatalf("shard bytes sum to %d", total)
+	}
+	// 空间不够时减少分片数
+	if c := newCache(2*minShardBytes, 16, nil, 0); len(c.shards) != 2 {
+		t.Fatalf("expect 2 shards, got %d", len(c.shards))
+	}
+	// 不限制空间时使用指定的分片数
+	if c := newCache(0, 16, nil, 0); len(c.shards) != 16 {
+		t.Fatalf("expect 16 shards, got %d", len(c.shards))
+	}
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		c.add(key, ByteView{b: []byte(key)})
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if v, ok := c.find(key); !ok || v.String() != key {
+			t.Fatalf("find %s failed", key)
+		}
+	}
+}
+
+// benchmarkGetParallel 多个协程并发地从缓存中读取已经存在的 key
+func benchmarkGetParallel(b *testing.B, shards int) {
+	g := NewGroup("bench"+strconv.Itoa(shards), 64<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithShards(shards))
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		g.Get(keys[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			g.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			benchmarkGetParallel(b, shards)
+		})
+	}
+}
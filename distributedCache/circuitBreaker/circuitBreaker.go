@@ -0,0 +1,207 @@
+package circuitBreaker
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 实现断路器：连续失败达到阈值后打开，打开期间的请求直接失败，不再访问远程节点；
+// 打开一段时间后进入半开状态，放行少量试探请求，全部成功则关闭，任意一个失败则重新打开
+
+// State 断路器的状态
+type State int
+
+const (
+	StateClosed   State = iota // 关闭，请求正常通过
+	StateOpen                  // 打开，请求直接失败
+	StateHalfOpen              // 半开，只放行有限个试探请求
+)
+
+// String 返回状态的名称
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "state(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+var (
+	// ErrOpen 断路器处于打开状态
+	ErrOpen = errors.New("circuit breaker is open")
+	// ErrTooManyRequests 断路器处于半开状态，试探请求的数量已经达到上限
+	ErrTooManyRequests = errors.New("circuit breaker is half-open: too many requests")
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 10 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// Settings 断路器的配置，零值的字段使用默认值
+type Settings struct {
+	Name             string                            // 断路器的名称，传给 OnStateChange
+	FailureThreshold int                               // 连续失败多少次打开断路器，默认为 5
+	OpenTimeout      time.Duration                     // 打开多久之后进入半开状态，默认为 10s
+	HalfOpenRequests int                               // 半开状态下放行的试探请求数，全部成功后关闭，默认为 1
+	OnStateChange    func(name string, from, to State) // 状态变化时的回调，可以为空，调用时不持有锁
+	now              func() time.Time                  // 获取当前时间，方便测试时替换
+}
+
+// Breaker 断路器，并发安全
+type Breaker struct {
+	settings Settings
+
+	mu         sync.Mutex
+	state      State
+	generation uint64    // 每次状态变化加一，忽略上一个状态中发出的请求的结果
+	failures   int       // 关闭状态下连续失败的次数
+	openedAt   time.Time // 最近一次打开的时间
+	inFlight   int       // 半开状态下已经放行的试探请求数
+	successes  int       // 半开状态下成功的试探请求数
+}
+
+// New 创建一个关闭状态的断路器
+func New(settings Settings) *Breaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = defaultFailureThreshold
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = defaultOpenTimeout
+	}
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	if settings.now == nil {
+		settings.now = time.Now
+	}
+	return &Breaker{settings: settings}
+}
+
+// State 返回断路器当前的状态
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	state, changed := b.currentState()
+	b.mu.Unlock()
+	b.notify(changed)
+	return state
+}
+
+// Allow 判断请求能否通过，能通过时返回 done，调用者必须在请求结束后调用 done 报告请求是否成功
+// 不能通过时返回 ErrOpen 或 ErrTooManyRequests
+func (b *Breaker) Allow() (done func(success bool), err error) {
+	done, _, err = b.Acquire()
+	return done, err
+}
+
+// Acquire 和 Allow 一样，另外返回 release，请求因为与远程节点无关的原因结束时（例如调用者主动取消）
+// 调用 release 代替 done，只归还半开状态下占用的试探名额，不记录成功或失败。done 和 release 只能调用其中一个
+func (b *Breaker) Acquire() (done func(success bool), release func(), err error) {
+	b.mu.Lock()
+	state, changed := b.currentState()
+	switch state {
+	case StateOpen:
+		err = ErrOpen
+	case StateHalfOpen:
+		if b.inFlight >= b.settings.HalfOpenRequests {
+			err = ErrTooManyRequests
+		} else {
+			b.inFlight++
+		}
+	}
+	generation := b.generation
+	b.mu.Unlock()
+	b.notify(changed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(success bool) { b.done(generation, success) }, func() { b.release(generation) }, nil
+}
+
+// Do 在断路器的保护下执行 fn，fn 返回 nil 表示成功
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err == nil)
+	return err
+}
+
+// done 记录一个请求的结果，generation 和当前不同说明状态已经变化过，结果作废
+func (b *Breaker) done(generation uint64, success bool) {
+	b.mu.Lock()
+	var changed []transition
+	if _, changed = b.currentState(); generation == b.generation {
+		switch b.state {
+		case StateClosed:
+			if success {
+				b.failures = 0
+			} else if b.failures++; b.failures >= b.settings.FailureThreshold {
+				changed = append(changed, b.setState(StateOpen))
+			}
+		case StateHalfOpen:
+			if !success {
+				changed = append(changed, b.setState(StateOpen))
+			} else if b.successes++; b.successes >= b.settings.HalfOpenRequests {
+				changed = append(changed, b.setState(StateClosed))
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.notify(changed)
+}
+
+// release 归还半开状态下的试探名额，不影响断路器的状态
+func (b *Breaker) release(generation uint64) {
+	b.mu.Lock()
+	if generation == b.generation && b.state == StateHalfOpen {
+		b.inFlight--
+	}
+	b.mu.Unlock()
+}
+
+// transition 一次状态变化
+type transition struct {
+	from, to State
+}
+
+// currentState 打开状态超过 OpenTimeout 时转为半开，返回当前状态和发生的状态变化，调用者需要持有锁
+func (b *Breaker) currentState() (State, []transition) {
+	if b.state == StateOpen && b.settings.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		t := b.setState(StateHalfOpen)
+		return b.state, []transition{t}
+	}
+	return b.state, nil
+}
+
+// setState 切换到新的状态并清空计数，调用者需要持有锁
+func (b *Breaker) setState(to State) transition {
+	t := transition{from: b.state, to: to}
+	b.state = to
+	b.generation++
+	b.failures, b.inFlight, b.successes = 0, 0, 0
+	if to == StateOpen {
+		b.openedAt = b.settings.now()
+	}
+	return t
+}
+
+// notify 在锁外调用 OnStateChange，避免回调中再访问断路器时死锁
+func (b *Breaker) notify(changed []transition) {
+	if b.settings.OnStateChange == nil {
+		return
+	}
+	for _, t := range changed {
+		b.settings.OnStateChange(b.settings.Name, t.from, t.to)
+	}
+}
@@ -0,0 +1,144 @@
+package circuitBreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestBreaker(transitions *[]string) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := New(Settings{
+		Name:             "peer",
+		FailureThreshold: 3,
+		OpenTimeout:      time.Second,
+		HalfOpenRequests: 2,
+		OnStateChange: func(name string, from, to State) {
+			*transitions = append(*transitions, name+":"+from.String()+"->"+to.String())
+		},
+		now: clock.now,
+	})
+	return b, clock
+}
+
+var errFail = errors.New("fail")
+
+func fail() error    { return errFail }
+func succeed() error { return nil }
+
+func TestBreakerOpen(t *testing.T) {
+	var transitions []string
+	b, clock := newTestBreaker(&transitions)
+
+	// 成功会清零连续失败的次数
+	b.Do(fail)
+	b.Do(fail)
+	b.Do(succeed)
+	b.Do(fail)
+	b.Do(fail)
+	if b.State() != StateClosed {
+		t.Fatalf("breaker should still be closed, got %v", b.State())
+	}
+	// 连续失败 3 次打开，之后的请求直接失败，不执行 fn
+	b.Do(fail)
+	if b.State() != StateOpen {
+		t.Fatalf("breaker should be open, got %v", b.State())
+	}
+	called := false
+	if err := b.Do(func() error { called = true; return nil }); err != ErrOpen || called {
+		t.Fatalf("open breaker should reject at once, got %v called=%v", err, called)
+	}
+
+	// 超过 OpenTimeout 后进入半开，最多放行 2 个试探请求
+	clock.t = clock.t.Add(time.Second)
+	done1, err1 := b.Allow()
+	done2, err2 := b.Allow()
+	if _, err := b.Allow(); err1 != nil || err2 != nil || err != ErrTooManyRequests {
+		t.Fatalf("half-open should allow exactly 2 trials: %v %v %v", err1, err2, err)
+	}
+	// 试探请求全部成功后关闭
+	done1(true)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("breaker should wait for all trials, got %v", b.State())
+	}
+	done2(true)
+	if b.State() != StateClosed {
+		t.Fatalf("breaker should be closed, got %v", b.State())
+	}
+
+	want := []string{"peer:closed->open", "peer:open->half-open", "peer:half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	var transitions []string
+	b, clock := newTestBreaker(&transitions)
+	for i := 0; i < 3; i++ {
+		b.Do(fail)
+	}
+	clock.t = clock.t.Add(time.Second)
+	// 试探请求失败，重新打开并重新计时
+	if err := b.Do(fail); err != errFail {
+		t.Fatalf("trial request should run, got %v", err)
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("breaker should be open again, got %v", b.State())
+	}
+	clock.t = clock.t.Add(time.Second / 2)
+	if err := b.Do(succeed); err != ErrOpen {
+		t.Fatalf("open timeout should restart, got %v", err)
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	var transitions []string
+	b, clock := newTestBreaker(&transitions)
+	for i := 0; i < 3; i++ {
+		b.Do(fail)
+	}
+	clock.t = clock.t.Add(time.Second)
+	// 被取消的试探请求既不关闭也不重新打开断路器，只归还名额
+	for i := 0; i < 3; i++ {
+		_, release, err := b.Acquire()
+		if err != nil {
+			t.Fatalf("trial %d rejected: %v", i, err)
+		}
+		release()
+	}
+	if b.State() != StateHalfOpen {
+		t.Fatalf("released trials should not change the state, got %v", b.State())
+	}
+	b.Do(succeed)
+	b.Do(succeed)
+	if b.State() != StateClosed {
+		t.Fatalf("breaker should be closed, got %v", b.State())
+	}
+}
+
+func TestBreakerStaleResult(t *testing.T) {
+	var transitions []string
+	b, _ := newTestBreaker(&transitions)
+	// 打开之前发出的慢请求，在打开之后才返回成功，不应该影响新的状态
+	slow, _ := b.Allow()
+	for i := 0; i < 3; i++ {
+		b.Do(fail)
+	}
+	slow(true)
+	if b.State() != StateOpen {
+		t.Fatalf("stale success should be ignored, got %v", b.State())
+	}
+}
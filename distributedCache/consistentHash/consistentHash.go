@@ -1,7 +1,6 @@
 package consistentHash
 
 import (
-	"fmt"
 	"hash/crc32"
 	"sort"
 	"strconv"
@@ -9,7 +8,7 @@ import (
 
 // 实现一致性哈希算法
 
-//Hash 函数类型 Hash，采取依赖注入的方式，允许用于替换成自定义的 Hash 函数，也方便测试时替换
+// Hash 函数类型 Hash，采取依赖注入的方式，允许用于替换成自定义的 Hash 函数，也方便测试时替换
 type Hash func(data []byte) uint32
 
 // Map 一致性哈希算法的主数据结构
@@ -18,6 +17,7 @@ type Map struct {
 	replicas int            // 虚拟节点倍数
 	keys     []int          // 哈希环
 	hashMap  map[int]string // 虚拟节点和真实节点的映射表，key 虚拟节点哈希值，值是真是节点名称
+	weights  map[string]int // 真实节点的权重，虚拟节点数为 replicas*weight
 }
 
 // New 构造函数
@@ -26,6 +26,7 @@ func New(replicas int, fn Hash) *Map {
 		hash:     fn,
 		replicas: replicas,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
 	}
 	// 如果用户没有传入哈希函数则默认使用 crc32.ChecksumIEEE
 	if m.hash == nil {
@@ -36,25 +37,70 @@ func New(replicas int, fn Hash) *Map {
 
 // Add 添加节点,也就是真实的机器
 func (m *Map) Add(addrs ...string) {
-	fmt.Printf("consistentHash.go: ADD() -> addrs = %s\n", addrs)
 	for _, addr := range addrs {
-		// 每一个真实节点创建 replicas 个虚拟节点
-		for i := 0; i < m.replicas; i++ {
-			// 计算虚拟节点的哈希值
-			hash := int(m.hash([]byte(strconv.Itoa(i) + addr)))
-			// 加入到环中
-			m.keys = append(m.keys, hash)
-			// 建立虚拟节点和真实节点的映射关系
-			m.hashMap[hash] = addr
-		}
+		m.add(addr, 1)
 	}
 	// 对环重新排序
 	sort.Ints(m.keys)
 }
 
+// AddWeighted 添加一个带权重的节点，虚拟节点数为 replicas*weight，节点分到的 key 与权重成正比
+// 例如 64GB 的节点权重为 4，16GB 的节点权重为 1，weight 小于 1 时按 1 处理
+// key 的比例能否接近权重取决于哈希函数对虚拟节点的离散程度，虚拟节点越多越接近
+func (m *Map) AddWeighted(addr string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.add(addr, weight)
+	sort.Ints(m.keys)
+}
+
+// add 为真实节点创建 replicas*weight 个虚拟节点，不对环排序
+func (m *Map) add(addr string, weight int) {
+	m.weights[addr] = weight
+	for i := 0; i < m.replicas*weight; i++ {
+		// 计算虚拟节点的哈希值
+		hash := int(m.hash([]byte(strconv.Itoa(i) + addr)))
+		// 加入到环中
+		m.keys = append(m.keys, hash)
+		// 建立虚拟节点和真实节点的映射关系
+		m.hashMap[hash] = addr
+	}
+}
+
+// Remove 删除节点，只删除这个节点的虚拟节点，其他节点的虚拟节点保持不变
+func (m *Map) Remove(addrs ...string) {
+	removed := false
+	for _, addr := range addrs {
+		weight, ok := m.weights[addr]
+		if !ok {
+			weight = 1
+		}
+		delete(m.weights, addr)
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + addr)))
+			// 虚拟节点的哈希值可能和其他节点冲突，只删除属于这个节点的映射
+			if m.hashMap[hash] == addr {
+				delete(m.hashMap, hash)
+				removed = true
+			}
+		}
+	}
+	if !removed {
+		return
+	}
+	// 重建哈希环，保留仍然存在映射关系的虚拟节点，原来的 keys 是有序的，过滤后仍然有序
+	keys := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			keys = append(keys, hash)
+		}
+	}
+	m.keys = keys
+}
+
 // Get 获取节点
 func (m *Map) Get(key string) string {
-	fmt.Printf("consistentHash.go: Get() -> key = %s\n", key)
 	// 没有环
 	if len(m.keys) == 0 {
 		return ""
@@ -68,3 +114,40 @@ func (m *Map) Get(key string) string {
 	// 通过虚拟节点找到真实节点, 取余是为了防止越界，因为是一个环
 	return m.hashMap[m.keys[index%len(m.keys)]]
 }
+
+// Walk 从 key 所在的位置开始顺时针遍历哈希环，依次对每个不同的真实节点调用 fn，fn 返回 false 时停止遍历
+// 第一个访问到的节点就是 Get 返回的节点，调用者可以借此跳过不可用的节点
+func (m *Map) Walk(key string, fn func(addr string) bool) {
+	if len(m.keys) == 0 {
+		return
+	}
+	hash := int(m.hash([]byte(key)))
+	index := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	visited := make(map[string]bool)
+	for i := 0; i < len(m.keys); i++ {
+		addr := m.hashMap[m.keys[(index+i)%len(m.keys)]]
+		if visited[addr] {
+			continue
+		}
+		visited[addr] = true
+		if !fn(addr) {
+			return
+		}
+	}
+}
+
+// GetN 返回 key 顺时针方向上 n 个不同的真实节点，用来保存 key 的 n 个副本，第一个就是 Get 返回的节点
+// 同一个真实节点的多个虚拟节点只算一次，真实节点不足 n 个时返回全部节点
+func (m *Map) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	addrs := make([]string, 0, n)
+	m.Walk(key, func(addr string) bool {
+		addrs = append(addrs, addr)
+		return len(addrs) < n
+	})
+	return addrs
+}
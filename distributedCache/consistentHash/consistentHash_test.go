@@ -1,7 +1,10 @@
 package consistentHash
 
 import (
+	"crypto/md5"
+	"encoding/binary"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -39,3 +42,107 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2", "8")
+	// 删除节点 8 之后，27 重新落到虚拟节点 02 上，其他 key 不受影响
+	hash.Remove("8")
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+	if len(hash.keys) != 9 || len(hash.hashMap) != 9 {
+		t.Errorf("virtual nodes of 8 not removed: %v", hash.keys)
+	}
+	// 删除所有节点之后环为空
+	hash.Remove("2", "4", "6")
+	if hash.Get("27") != "" {
+		t.Errorf("empty ring should yield nothing")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+	// 23 从虚拟节点 24 开始顺时针遍历，依次经过 24,26,02，每个真实节点只访问一次
+	var got []string
+	hash.Walk("23", func(addr string) bool {
+		got = append(got, addr)
+		return true
+	})
+	if strings.Join(got, ",") != "4,6,2" {
+		t.Errorf("Walk(23) visited %v", got)
+	}
+	// fn 返回 false 时停止遍历
+	got = got[:0]
+	hash.Walk("27", func(addr string) bool {
+		got = append(got, addr)
+		return addr != "4"
+	})
+	if strings.Join(got, ",") != "2,4" {
+		t.Errorf("Walk(27) visited %v", got)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+	// 11 落在虚拟节点 12 上，之后是 14,16，同一个真实节点的虚拟节点只算一次
+	if got := strings.Join(hash.GetN("11", 2), ","); got != "2,4" {
+		t.Errorf("GetN(11, 2) = %s", got)
+	}
+	// 真实节点不足 n 个时返回全部节点
+	if got := strings.Join(hash.GetN("11", 5), ","); got != "2,4,6" {
+		t.Errorf("GetN(11, 5) = %s", got)
+	}
+	if got := hash.GetN("11", 0); len(got) != 0 {
+		t.Errorf("GetN(11, 0) = %v", got)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	// crc32 对相邻的虚拟节点名称离散程度不够，这里用 md5 检验 key 的比例与权重一致
+	hash := New(50, func(data []byte) uint32 {
+		sum := md5.Sum(data)
+		return binary.BigEndian.Uint32(sum[:])
+	})
+	// 64GB 的节点权重为 4，两个 16GB 的节点权重为 1
+	hash.AddWeighted("big", 4)
+	hash.AddWeighted("small1", 1)
+	hash.Add("small2")
+	counts := make(map[string]int)
+	const keys = 100000
+	for i := 0; i < keys; i++ {
+		counts[hash.Get("key"+strconv.Itoa(i))]++
+	}
+	want := map[string]float64{"big": 4.0 / 6, "small1": 1.0 / 6, "small2": 1.0 / 6}
+	for addr, share := range want {
+		got := float64(counts[addr]) / keys
+		if got < share-0.05 || got > share+0.05 {
+			t.Errorf("%s got %.3f of keys, want %.3f", addr, got, share)
+		}
+	}
+
+	// 删除带权重的节点时删除它的全部虚拟节点
+	hash.Remove("big")
+	if len(hash.keys) != 100 || len(hash.hashMap) != 100 {
+		t.Errorf("virtual nodes of big not removed: %d left", len(hash.keys))
+	}
+}
@@ -0,0 +1,112 @@
+package consistentHash
+
+import "strconv"
+
+// 实现 Google 的 jump 一致性哈希（A Fast, Minimal Memory, Consistent Hash Algorithm）：
+// 把 key 映射到 [0, n) 中的一个编号，不需要保存哈希环，n 增加到 n+1 时只有 1/(n+1) 的 key 移动到新的编号上。
+// 适合节点按编号寻址、只在末尾增删的集群，例如有状态服务的第 0..n-1 个副本。
+// 节点的编号取决于 Add 和 Remove 的顺序，多个节点必须按同一个全局顺序添加相同的节点才能对 key 的归属达成一致，
+// 按排序后的顺序重建时，在中间插入节点会让之后所有节点的编号加一，失去只移动 1/(n+1) 的保证
+
+// Jump jump 一致性哈希的主数据结构，并发访问是不安全的
+type Jump struct {
+	hash  Hash64
+	addrs []string       // 编号 -> 节点
+	index map[string]int // 节点 -> 编号
+}
+
+// NewJump 构造函数，fn 为 nil 时使用默认的哈希函数
+func NewJump(fn Hash64) *Jump {
+	if fn == nil {
+		fn = defaultHash64
+	}
+	return &Jump{hash: fn, index: make(map[string]int)}
+}
+
+// jumpHash 论文中的算法，返回 key 在 n 个编号中的编号
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Add 按顺序在末尾添加节点，已经存在的节点会被忽略
+func (m *Jump) Add(addrs ...string) {
+	for _, addr := range addrs {
+		if _, ok := m.index[addr]; ok {
+			continue
+		}
+		m.index[addr] = len(m.addrs)
+		m.addrs = append(m.addrs, addr)
+	}
+}
+
+// Remove 删除节点，删除最后一个节点时只有它的 key 会移动；
+// 删除中间的节点时把最后一个节点移到它的编号上，最后一个节点的一部分 key 也会移动
+func (m *Jump) Remove(addrs ...string) {
+	for _, addr := range addrs {
+		i, ok := m.index[addr]
+		if !ok {
+			continue
+		}
+		last := len(m.addrs) - 1
+		m.addrs[i] = m.addrs[last]
+		m.index[m.addrs[i]] = i
+		m.addrs = m.addrs[:last]
+		delete(m.index, addr)
+	}
+}
+
+// Get 返回 key 对应编号上的节点
+func (m *Jump) Get(key string) string {
+	if len(m.addrs) == 0 {
+		return ""
+	}
+	return m.addrs[jumpHash(m.hash([]byte(key)), len(m.addrs))]
+}
+
+// GetN 返回 key 对应的 n 个不同的节点
+func (m *Jump) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	addrs := make([]string, 0, n)
+	m.Walk(key, func(addr string) bool {
+		addrs = append(addrs, addr)
+		return len(addrs) < n
+	})
+	return addrs
+}
+
+// Walk 从 key 对应的编号开始依次遍历所有节点，后面的节点用 key 加上序号重新计算编号，
+// 跳过已经访问过的节点，fn 返回 false 时停止遍历
+func (m *Jump) Walk(key string, fn func(addr string) bool) {
+	n := len(m.addrs)
+	if n == 0 {
+		return
+	}
+	visited := make([]bool, n)
+	h := m.hash([]byte(key))
+	for i, found := 0, 0; found < n; i++ {
+		var b int
+		if i < 4*n {
+			b = jumpHash(h, n)
+			h = m.hash([]byte(key + "#" + strconv.Itoa(i)))
+		} else {
+			// 重新计算很多次仍然没有访问到的节点按编号顺序补上
+			b = (i - 4*n) % n
+		}
+		if visited[b] {
+			continue
+		}
+		visited[b] = true
+		found++
+		if !fn(m.addrs[b]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package consistentHash
+
+import "sort"
+
+// 实现 Maglev 哈希（Maglev: A Fast and Reliable Software Network Load Balancer）：
+// 节点变化时为每个节点生成一个长度为 M 的排列，各节点轮流按自己的排列认领查找表中的空位，
+// 查找时只需要计算 key 的哈希值并取一次查找表，不需要二分查找。M 是远大于节点数的质数，
+// 每个节点认领的位置数几乎相同，节点变化时大部分位置的归属保持不变
+
+// defaultMaglevSize 查找表的默认大小，必须是质数
+const defaultMaglevSize = 65537
+
+// Maglev Maglev 哈希的主数据结构，并发访问是不安全的
+type Maglev struct {
+	hash  Hash64
+	size  int      // 查找表的大小 M，质数
+	addrs []string // 按名称排序的节点，保证节点的添加顺序不影响查找表
+	table []int    // 查找表，位置 -> 节点在 addrs 中的下标
+}
+
+// NewMaglev 构造函数，size 为查找表的大小，不是质数时使用大于它的最小质数，为 0 时使用 65537，
+// 节点数应该远小于 size，fn 为 nil 时使用默认的哈希函数
+func NewMaglev(size int, fn Hash64) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	for !isPrime(size) {
+		size++
+	}
+	if fn == nil {
+		fn = defaultHash64
+	}
+	return &Maglev{hash: fn, size: size}
+}
+
+// isPrime 判断 n 是否是质数
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add 添加节点并重建查找表，已经存在的节点会被忽略
+func (m *Maglev) Add(addrs ...string) {
+	changed := false
+	for _, addr := range addrs {
+		i := sort.SearchStrings(m.addrs, addr)
+		if i < len(m.addrs) && m.addrs[i] == addr {
+			continue
+		}
+		m.addrs = append(m.addrs, "")
+		copy(m.addrs[i+1:], m.addrs[i:])
+		m.addrs[i] = addr
+		changed = true
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+// Remove 删除节点并重建查找表
+func (m *Maglev) Remove(addrs ...string) {
+	changed := false
+	for _, addr := range addrs {
+		i := sort.SearchStrings(m.addrs, addr)
+		if i < len(m.addrs) && m.addrs[i] == addr {
+			m.addrs = append(m.addrs[:i], m.addrs[i+1:]...)
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+// populate 按照论文中的算法填充查找表：节点 i 的排列为 (offset + j*skip) % M，
+// 各节点轮流取自己排列中下一个还没有被认领的位置，直到所有位置都被认领
+func (m *Maglev) populate() {
+	n := len(m.addrs)
+	if n == 0 {
+		m.table = nil
+		return
+	}
+	M := uint64(m.size)
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	for i, addr := range m.addrs {
+		h := m.hash([]byte(addr))
+		offsets[i] = h % M
+		skips[i] = mix64(h)%(M-1) + 1
+	}
+	next := make([]uint64, n)
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := 0; ; {
+		for i := 0; i < n; i++ {
+			c := (offsets[i] + next[i]*skips[i]) % M
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % M
+			}
+			table[c] = i
+			next[i]++
+			if filled++; filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+// slot key 在查找表中的位置
+func (m *Maglev) slot(key string) int {
+	return int(m.hash([]byte(key)) % uint64(m.size))
+}
+
+// Get 返回 key 所属的节点，只需要取一次查找表
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.addrs[m.table[m.slot(key)]]
+}
+
+// GetN 返回 key 的 n 个不同的节点，第一个就是 Get 返回的节点
+func (m *Maglev) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	addrs := make([]string, 0, n)
+	m.Walk(key, func(addr string) bool {
+		addrs = append(addrs, addr)
+		return len(addrs) < n
+	})
+	return addrs
+}
+
+// Walk 从 key 在查找表中的位置开始向后遍历，依次访问不同的节点，fn 返回 false 时停止遍历
+// 查找表中各节点的位置是交错的，后面的节点近似随机，不会都落到同一个相邻节点上
+func (m *Maglev) Walk(key string, fn func(addr string) bool) {
+	if len(m.table) == 0 {
+		return
+	}
+	visited := make([]bool, len(m.addrs))
+	start, found := m.slot(key), 0
+	for i := 0; i < m.size && found < len(m.addrs); i++ {
+		node := m.table[(start+i)%m.size]
+		if visited[node] {
+			continue
+		}
+		visited[node] = true
+		found++
+		if !fn(m.addrs[node]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package consistentHash
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMaglevMovement(t *testing.T) {
+	// Maglev 添加节点时其它节点之间也会有少量位置交换，所以只检查比例
+	m := NewMaglev(0, nil)
+	var nodes []string
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, "node"+strconv.Itoa(i))
+	}
+	m.Add(nodes...)
+	before := owners(m)
+	m.Add("node10")
+	after := owners(m)
+	moved, toNew := 0, 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+			if after[i] == "node10" {
+				toNew++
+			}
+		}
+	}
+	if share := float64(moved) / movementKeys; share > 1.5/11 || float64(toNew)/float64(moved) < 0.8 {
+		t.Fatalf("%.3f of keys moved after adding a node, %d of %d to the new node", share, toNew, moved)
+	}
+	m.Remove("node10")
+	for i, owner := range owners(m) {
+		if owner != before[i] {
+			t.Fatalf("key%d should be back on %s, got %s", i, before[i], owner)
+		}
+	}
+}
+
+// TestMaglevDisruption 测量删除一个节点时的扰动：被删除节点的 key 必须移动，其余节点上移动的 key 越少越好
+func TestMaglevDisruption(t *testing.T) {
+	for _, n := range []int{5, 10, 50} {
+		m := NewMaglev(0, nil)
+		for i := 0; i < n; i++ {
+			m.Add("node" + strconv.Itoa(i))
+		}
+		before := owners(m)
+		m.Remove("node0")
+		after := owners(m)
+		removed, disrupted := 0, 0
+		for i := range before {
+			if before[i] == "node0" {
+				removed++
+			} else if before[i] != after[i] {
+				disrupted++
+			}
+		}
+		share := float64(disrupted) / float64(movementKeys-removed)
+		t.Logf("%d nodes: %.2f%% of keys on the removed node, %.2f%% of other keys disrupted",
+			n, 100*float64(removed)/movementKeys, 100*share)
+		if share > 0.05 {
+			t.Errorf("%d nodes: %.2f%% of keys on remaining nodes moved", n, 100*share)
+		}
+	}
+}
+
+func TestMaglevBalance(t *testing.T) {
+	m := NewMaglev(0, nil)
+	for i := 0; i < 10; i++ {
+		m.Add("node" + strconv.Itoa(i))
+	}
+	// 每个节点认领的位置数几乎相同
+	counts := make(map[int]int)
+	for _, node := range m.table {
+		counts[node]++
+	}
+	for node, c := range counts {
+		if c < m.size/10*95/100 || c > m.size/10*105/100 {
+			t.Fatalf("node %d owns %d of %d slots", node, c, m.size)
+		}
+	}
+	if got := NewMaglev(10, nil).size; got != 11 {
+		t.Fatalf("table size should be rounded up to a prime, got %d", got)
+	}
+}
+
+func benchmarkGet(b *testing.B, s selector) {
+	for i := 0; i < 10; i++ {
+		s.Add("node" + strconv.Itoa(i))
+	}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkRingGet(b *testing.B)       { benchmarkGet(b, New(50, nil)) }
+func BenchmarkMaglevGet(b *testing.B)     { benchmarkGet(b, NewMaglev(0, nil)) }
+func BenchmarkRendezvousGet(b *testing.B) { benchmarkGet(b, NewRendezvous(nil)) }
+func BenchmarkJumpGet(b *testing.B)       { benchmarkGet(b, NewJump(nil)) }
+
+func BenchmarkMaglevPopulate(b *testing.B) {
+	m := NewMaglev(0, nil)
+	for i := 0; i < 10; i++ {
+		m.Add("node" + strconv.Itoa(i))
+	}
+	for i := 0; i < b.N; i++ {
+		m.populate()
+	}
+}
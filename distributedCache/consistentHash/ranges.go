@@ -0,0 +1,78 @@
+package consistentHash
+
+import "sort"
+
+// 计算两个哈希环之间归属发生变化的区间，节点变化后新的 owner 据此从原来的 owner 迁移缓存值
+
+// Range 哈希环上的一段区间 (Start, End]，Start >= End 时跨过了 0，From 和 To 是区间原来和现在所属的节点
+type Range struct {
+	Start uint32
+	End   uint32
+	From  string // 区间原来所属的节点，原来的环为空时为空字符串
+	To    string // 区间现在所属的节点，现在的环为空时为空字符串
+}
+
+// Contains 判断哈希值是否落在区间内
+func (r Range) Contains(hash uint32) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	// 跨过 0 的区间，Start == End 表示整个环
+	return hash > r.Start || hash <= r.End
+}
+
+// Hash 返回 key 在哈希环上的位置，与 Get 使用的哈希函数相同
+func (m *Map) Hash(key string) uint32 {
+	return m.hash([]byte(key))
+}
+
+// owner 返回哈希值 hash 顺时针方向上第一个虚拟节点所属的真实节点
+func (m *Map) owner(hash int) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	index := sort.SearchInts(m.keys, hash)
+	return m.hashMap[m.keys[index%len(m.keys)]]
+}
+
+// Diff 返回从 old 变为 new 时归属发生变化的区间，相邻并且 From、To 相同的区间会被合并
+// 两个环必须使用相同的哈希函数。把两个环的虚拟节点合并排序后，相邻两个位置之间的区间内没有任何虚拟节点，
+// 区间内所有哈希值在两个环中的归属都和区间的终点相同
+func Diff(old, new *Map) []Range {
+	points := make([]int, 0, len(old.keys)+len(new.keys))
+	points = append(points, old.keys...)
+	points = append(points, new.keys...)
+	sort.Ints(points)
+	// 去重
+	n := 0
+	for i, p := range points {
+		if i == 0 || p != points[n-1] {
+			points[n] = p
+			n++
+		}
+	}
+	points = points[:n]
+
+	var ranges []Range
+	for i, end := range points {
+		// 第一个区间的起点是最后一个位置，跨过了 0
+		start := points[(i+len(points)-1)%len(points)]
+		from, to := old.owner(end), new.owner(end)
+		if from == to {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End == uint32(start) &&
+			ranges[last].From == from && ranges[last].To == to {
+			ranges[last].End = uint32(end)
+			continue
+		}
+		ranges = append(ranges, Range{Start: uint32(start), End: uint32(end), From: from, To: to})
+	}
+	// 最后一个区间和第一个区间在 0 处相接时合并
+	if k := len(ranges) - 1; k > 0 && ranges[k].End == ranges[0].Start &&
+		ranges[k].From == ranges[0].From && ranges[k].To == ranges[0].To {
+		ranges[0].Start = ranges[k].Start
+		ranges = ranges[:k]
+	}
+	return ranges
+}
@@ -0,0 +1,67 @@
+package consistentHash
+
+import (
+	"strconv"
+	"testing"
+)
+
+// checkDiff 每个 key 的归属发生变化当且仅当它落在某个区间内，并且区间的 From、To 与两个环的 Get 一致
+func checkDiff(t *testing.T, old, new *Map) []Range {
+	t.Helper()
+	ranges := Diff(old, new)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		from, to := old.Get(key), new.Get(key)
+		var found *Range
+		for j := range ranges {
+			if ranges[j].Contains(new.Hash(key)) {
+				found = &ranges[j]
+				break
+			}
+		}
+		if from == to && found != nil {
+			t.Fatalf("key %s did not move but is in range %+v", key, *found)
+		}
+		if from != to && (found == nil || found.From != from || found.To != to) {
+			t.Fatalf("key %s moved %s -> %s, range = %v", key, from, to, found)
+		}
+	}
+	return ranges
+}
+
+func TestDiff(t *testing.T) {
+	old := New(50, nil)
+	old.Add("a", "b", "c")
+	added := New(50, nil)
+	added.Add("a", "b", "c", "d")
+	// 新增节点时，所有变化的区间都是从原来的节点移动到新节点
+	for _, r := range checkDiff(t, old, added) {
+		if r.To != "d" || r.From == "d" {
+			t.Fatalf("unexpected range %+v", r)
+		}
+	}
+	removed := New(50, nil)
+	removed.Add("a", "c")
+	for _, r := range checkDiff(t, old, removed) {
+		if r.From != "b" {
+			t.Fatalf("unexpected range %+v", r)
+		}
+	}
+	// 原来的环为空时整个环都发生了变化
+	checkDiff(t, New(50, nil), old)
+	if ranges := Diff(old, old); len(ranges) != 0 {
+		t.Fatalf("Diff of identical rings = %v", ranges)
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	r := Range{Start: 10, End: 20}
+	if r.Contains(10) || !r.Contains(11) || !r.Contains(20) || r.Contains(21) {
+		t.Fatalf("Contains is wrong for %+v", r)
+	}
+	// 跨过 0 的区间
+	r = Range{Start: 20, End: 10}
+	if r.Contains(15) || !r.Contains(21) || !r.Contains(0) || !r.Contains(10) {
+		t.Fatalf("Contains is wrong for %+v", r)
+	}
+}
@@ -0,0 +1,121 @@
+package consistentHash
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// 实现 rendezvous 哈希（最高随机权重，HRW）：对每个 key 计算它和每个节点的得分，得分最高的节点负责这个 key，
+// 不需要虚拟节点，增删一个节点时只有得分最高的是这个节点的 key 会移动，代价是每次选择都要计算所有节点的得分
+
+// Hash64 64 位的哈希函数，rendezvous 和 jump 哈希需要更大的取值范围
+type Hash64 func(data []byte) uint64
+
+// defaultHash64 fnv-1a 之后再用 splitmix64 打散，相近的输入也能得到分布均匀的结果
+func defaultHash64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 splitmix64 的最后一步
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Rendezvous rendezvous 哈希的主数据结构，并发访问是不安全的
+type Rendezvous struct {
+	hash    Hash64
+	weights map[string]float64 // 节点和权重
+}
+
+// NewRendezvous 构造函数，fn 为 nil 时使用默认的哈希函数
+func NewRendezvous(fn Hash64) *Rendezvous {
+	if fn == nil {
+		fn = defaultHash64
+	}
+	return &Rendezvous{hash: fn, weights: make(map[string]float64)}
+}
+
+// Add 添加权重为 1 的节点
+func (r *Rendezvous) Add(addrs ...string) {
+	for _, addr := range addrs {
+		r.weights[addr] = 1
+	}
+}
+
+// AddWeighted 添加一个带权重的节点，节点分到的 key 与权重成正比，weight 小于 1 时按 1 处理
+func (r *Rendezvous) AddWeighted(addr string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	r.weights[addr] = float64(weight)
+}
+
+// Remove 删除节点
+func (r *Rendezvous) Remove(addrs ...string) {
+	for _, addr := range addrs {
+		delete(r.weights, addr)
+	}
+}
+
+// score key 在节点 addr 上的得分，使用加权 rendezvous 的 -w/ln(u)，u 是 (0,1) 上均匀分布的哈希值
+func (r *Rendezvous) score(key, addr string) float64 {
+	h := r.hash([]byte(addr + key))
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -r.weights[addr] / math.Log(u)
+}
+
+// Get 返回得分最高的节点
+func (r *Rendezvous) Get(key string) string {
+	var best string
+	var bestScore float64
+	for addr := range r.weights {
+		if s := r.score(key, addr); best == "" || s > bestScore || (s == bestScore && addr < best) {
+			best, bestScore = addr, s
+		}
+	}
+	return best
+}
+
+// GetN 返回得分最高的 n 个节点
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	addrs := make([]string, 0, n)
+	r.Walk(key, func(addr string) bool {
+		addrs = append(addrs, addr)
+		return len(addrs) < n
+	})
+	return addrs
+}
+
+// Walk 按得分从高到低遍历所有节点，fn 返回 false 时停止遍历
+func (r *Rendezvous) Walk(key string, fn func(addr string) bool) {
+	type scored struct {
+		addr  string
+		score float64
+	}
+	nodes := make([]scored, 0, len(r.weights))
+	for addr := range r.weights {
+		nodes = append(nodes, scored{addr, r.score(key, addr)})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].score != nodes[j].score {
+			return nodes[i].score > nodes[j].score
+		}
+		return nodes[i].addr < nodes[j].addr
+	})
+	for _, node := range nodes {
+		if !fn(node.addr) {
+			return
+		}
+	}
+}
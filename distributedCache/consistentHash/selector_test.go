@@ -0,0 +1,113 @@
+package consistentHash
+
+import (
+	"strconv"
+	"testing"
+)
+
+// selector 三种节点选择策略共同的方法
+type selector interface {
+	Add(addrs ...string)
+	Remove(addrs ...string)
+	Get(key string) string
+	GetN(key string, n int) []string
+}
+
+const movementKeys = 100000
+
+func owners(s selector) []string {
+	owners := make([]string, movementKeys)
+	for i := range owners {
+		owners[i] = s.Get("key" + strconv.Itoa(i))
+	}
+	return owners
+}
+
+// checkMovement 检查添加一个节点时只有移动到新节点上的 key 发生变化，并且比例接近 1/(n+1)，
+// 删除这个节点后所有 key 回到原来的节点
+func checkMovement(t *testing.T, s selector, maxShare float64) {
+	t.Helper()
+	var nodes []string
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, "node"+strconv.Itoa(i))
+	}
+	s.Add(nodes...)
+	before := owners(s)
+
+	s.Add("node10")
+	after := owners(s)
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			if after[i] != "node10" {
+				t.Fatalf("key%d moved from %s to %s, not to the new node", i, before[i], after[i])
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / movementKeys; share == 0 || share > maxShare {
+		t.Fatalf("%.3f of keys moved after adding a node, want (0, %.3f]", share, maxShare)
+	}
+
+	s.Remove("node10")
+	for i, owner := range owners(s) {
+		if owner != before[i] {
+			t.Fatalf("key%d should be back on %s, got %s", i, before[i], owner)
+		}
+	}
+
+	// GetN 返回不同的节点，第一个就是 Get 返回的节点
+	replicas := s.GetN("key0", 3)
+	if len(replicas) != 3 || replicas[0] != before[0] || replicas[1] == replicas[0] || replicas[2] == replicas[1] || replicas[2] == replicas[0] {
+		t.Fatalf("GetN(key0, 3) = %v, Get = %s", replicas, before[0])
+	}
+}
+
+func TestRingMovement(t *testing.T) {
+	// 虚拟节点有限时哈希环的比例波动较大
+	checkMovement(t, New(50, nil), 2.0/11)
+}
+
+func TestRendezvousMovement(t *testing.T) {
+	checkMovement(t, NewRendezvous(nil), 1.2/11)
+}
+
+func TestJumpMovement(t *testing.T) {
+	checkMovement(t, NewJump(nil), 1.2/11)
+}
+
+func TestJumpRemoveMiddle(t *testing.T) {
+	j := NewJump(nil)
+	for i := 0; i < 10; i++ {
+		j.Add("node" + strconv.Itoa(i))
+	}
+	before := owners(j)
+	// 删除中间的节点，最后一个节点 node9 移到它的编号上，只有这两个节点的 key 会移动
+	j.Remove("node3")
+	after := owners(j)
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			if before[i] != "node3" && before[i] != "node9" {
+				t.Fatalf("key%d moved from %s to %s", i, before[i], after[i])
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / movementKeys; share > 2.4/10 {
+		t.Fatalf("%.3f of keys moved after removing a middle node", share)
+	}
+}
+
+func TestRendezvousWeighted(t *testing.T) {
+	r := NewRendezvous(nil)
+	r.AddWeighted("big", 4)
+	r.Add("small1", "small2")
+	counts := make(map[string]int)
+	for i := 0; i < movementKeys; i++ {
+		counts[r.Get("key"+strconv.Itoa(i))]++
+	}
+	if share := float64(counts["big"]) / movementKeys; share < 4.0/6-0.02 || share > 4.0/6+0.02 {
+		t.Fatalf("big got %.3f of keys, want %.3f", share, 4.0/6)
+	}
+}
@@ -1,11 +1,15 @@
 package distributedCache
 
 import (
+	"context"
+	"distributedCache/eviction"
 	"distributedCache/pb"
 	"distributedCache/singleFlight"
-	"fmt"
+	"errors"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 // 核心的数据结构，负责与用户的交互，并且控制缓存值存储和获取的流程
@@ -31,33 +35,145 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// TTLGetter 可以为每个 key 单独指定过期时间的数据源，ttl 为 0 时使用 Group 的默认过期时间
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// TTLGetterFunc 接口型函数，同时实现了 Getter 和 TTLGetter 接口，可以直接传给 NewGroup
+type TTLGetterFunc func(key string) ([]byte, time.Duration, error)
+
+// GetWithTTL 回调方法实现
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// Get 忽略过期时间，实现 Getter 接口
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+// GetterCtx 支持 context 的数据源，数据源较慢时可以响应调用者的取消和超时
+type GetterCtx interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterCtxFunc 接口型函数，同时实现了 Getter 和 GetterCtx 接口，可以直接传给 NewGroup
+type GetterCtxFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext 回调方法实现
+func (f GetterCtxFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get 使用 context.Background() 调用，实现 Getter 接口
+func (f GetterCtxFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// TTLGetterCtx 同时支持 context 和每个 key 单独的过期时间的数据源，ttl 为 0 时使用 Group 的默认过期时间
+type TTLGetterCtx interface {
+	GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// TTLGetterCtxFunc 接口型函数，同时实现了 Getter、TTLGetter、GetterCtx 和 TTLGetterCtx 接口，可以直接传给 NewGroup
+type TTLGetterCtxFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+// GetContextWithTTL 回调方法实现
+func (f TTLGetterCtxFunc) GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
+// GetWithTTL 使用 context.Background() 调用，实现 TTLGetter 接口
+func (f TTLGetterCtxFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(context.Background(), key)
+}
+
+// GetContext 忽略过期时间，实现 GetterCtx 接口
+func (f TTLGetterCtxFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	bytes, _, err := f(ctx, key)
+	return bytes, err
+}
+
+// Get 使用 context.Background() 调用并忽略过期时间，实现 Getter 接口
+func (f TTLGetterCtxFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
 // 定义和初始化一些常使用的变量
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
+const (
+	defaultHotCacheRatio = 0.125            // 热点缓存默认占用 cacheBytes 的比例
+	hotCacheSampling     = 10               // 从远程节点获取的缓存值，平均每 hotCacheSampling 个中有一个会放入热点缓存
+	defaultNegativeRatio = 0.0625           // 不存在的 key 默认占用 cacheBytes 的比例
+	defaultNegativeTTL   = 10 * time.Second // 不存在的 key 默认缓存的时间
+)
+
 // Group 定义：一个 group 是一个缓存命名空间和相关的数据加载分布
 type Group struct {
-	name      string                     // 每个 Group 拥有一个唯一的名称 name
-	getter    Getter                     // 缓存未命中时获取源数据的回调(callback)
-	mainCache cache                      // 采用 LRU 实现的单机并发安全缓存
-	peers     PeerPicker                 // 支持选择节点并获取对应节点的缓存数据
-	loader    *singleFlight.SingleFlight // 使用 singleFlight, 保证相同的 key 只会发起一次请求
+	name            string                     // 每个 Group 拥有一个唯一的名称 name
+	getter          Getter                     // 缓存未命中时获取源数据的回调(callback)
+	mainCache       *cache                     // 单机并发安全缓存，保存本节点负责的 key
+	hotCache        *cache                     // 热点缓存，保存从远程节点获取的一部分缓存值，减少节点间的请求
+	negCache        *cache                     // 保存数据源返回 ErrNotFound 的 key，避免不存在的 key 每次都访问数据源
+	peers           PeerPicker                 // 支持选择节点并获取对应节点的缓存数据
+	loader          *singleFlight.SingleFlight // 使用 singleFlight, 保证相同的 key 只会发起一次请求
+	ttl             time.Duration              // 默认的缓存过期时间，为 0 表示永不过期
+	cacheBytes      int64                      // mainCache 和 hotCache 共用的最大缓存空间
+	hotCacheRatio   float64                    // hotCache 占用 cacheBytes 的比例
+	negativeRatio   float64                    // negCache 占用 cacheBytes 的比例
+	negativeTTL     time.Duration              // 不存在的 key 缓存的时间，为 0 表示不缓存
+	staleWindow     time.Duration              // 过期之后这段时间内立即返回旧值并在后台刷新
+	staleGrace      time.Duration              // 过期之后这段时间内重新加载失败时返回旧值
+	refreshing      sync.Map                   // 正在后台刷新的 key
+	earlyBeta       float64                    // XFetch 提前刷新的 beta，为 0 表示不提前刷新
+	sweepInterval   time.Duration              // 后台清理过期缓存的时间间隔
+	newPolicy       eviction.Factory           // 淘汰策略的构造函数，为 nil 时使用 LRUPolicy
+	shards          int                        // 缓存的分片数
+	replicateWrites bool                       // 是否把写入推送到 key 的所有副本
+	Stats           Stats                      // Group 的统计信息
+	peerLatency     histogram                  // 请求远程节点的耗时
 }
 
-// NewGroup 实例化
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+// NewGroup 实例化，opts 为可选配置，例如 WithTTL 设置默认的过期时间
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleFlight.SingleFlight{},
+		name:          name,
+		getter:        getter,
+		loader:        &singleFlight.SingleFlight{},
+		cacheBytes:    cacheBytes,
+		hotCacheRatio: defaultHotCacheRatio,
+		negativeRatio: defaultNegativeRatio,
+		negativeTTL:   defaultNegativeTTL,
+		shards:        defaultShards,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	// 热点缓存和不存在的 key 的空间从 cacheBytes 中划分出来，cacheBytes 为 0 表示不限制，三者都不限制
+	hotBytes := int64(float64(cacheBytes) * g.hotCacheRatio)
+	negBytes := int64(float64(cacheBytes) * g.negativeRatio)
+	g.mainCache = newCache(cacheBytes-hotBytes-negBytes, g.shards, g.newPolicy, g.sweepInterval)
+	g.hotCache = newCache(hotBytes, g.shards, g.newPolicy, g.sweepInterval)
+	g.negCache = newCache(negBytes, g.shards, g.newPolicy, g.sweepInterval)
+	// cacheBytes 很小时按比例划分的空间会舍入为 0，newCache 会把 0 当作不限制，这时改为不使用这个缓存
+	g.hotCache.disabled = cacheBytes > 0 && hotBytes <= 0
+	g.negCache.disabled = cacheBytes > 0 && negBytes <= 0
+	// 过期的缓存值要在 stale 窗口内继续保留
+	g.mainCache.staleFor = g.staleWindow
+	if g.staleGrace > g.staleWindow {
+		g.mainCache.staleFor = g.staleGrace
 	}
 	groups[name] = g
 	return g
@@ -71,61 +187,389 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// groupsWith 返回使用 peers 选择节点的所有 Group
+func groupsWith(peers PeerPicker) []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	var gs []*Group
+	for _, g := range groups {
+		if g.peers == peers {
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
 // Get 实现核心的 Get 方法，从缓存中通过 key 得到 value
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 和 Get 一样，ctx 被取消或超时后立即返回，ctx 会传递给数据源和远程节点
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, true)
+}
+
+// getForPeer 响应其它节点的 Get 请求，未命中时在本节点加载，不再转发给其它节点。
+// 发来请求的节点已经选过节点了，对冲、重试和有界负载选中的备用节点如果再转发，请求会绕回 key 所属的节点
+func (g *Group) getForPeer(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, false)
+}
+
+// get 查找缓存，未命中时加载，forward 为 false 时不从远程节点获取
+func (g *Group) get(ctx context.Context, key string, forward bool) (ByteView, error) {
 	// 如果 key 是空的
+	g.Stats.Gets.Add(1)
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, errKeyRequired
 	}
 	// 如果查找到了,返回缓存
+	var stale ByteView
+	var hasStale bool
 	if v, ok := g.mainCache.find(key); ok {
-		log.Println("[Cache] hit")
+		now := time.Now()
+		if !v.expired(now) {
+			g.Stats.CacheHits.Add(1)
+			// 快要过期时按概率选中少数调用者在后台提前刷新，其余调用者继续命中，避免过期的瞬间所有请求一起落到数据源
+			if v.refreshEarly(now, g.earlyBeta) && g.refresh(key) {
+				g.Stats.EarlyRefreshes.Add(1)
+			}
+			return v, nil
+		}
+		// 已经过期但还在 stale 窗口内：stale-while-revalidate 窗口内立即返回旧值并在后台刷新，
+		// 之后的 stale-if-error 窗口内先重新加载，失败时才返回旧值
+		v.s = true
+		if now.Sub(v.e) <= g.staleWindow {
+			g.Stats.StaleHits.Add(1)
+			g.refresh(key)
+			return v, nil
+		}
+		stale, hasStale = v, now.Sub(v.e) <= g.staleGrace
+	}
+	// 再查找热点缓存，命中则不需要再向远程节点请求
+	if v, ok := g.hotCache.find(key); ok {
+		g.Stats.HotCacheHits.Add(1)
 		return v, nil
 	}
+	// 最近确认过不存在的 key 直接返回，不再加载
+	if _, ok := g.negCache.find(key); ok {
+		g.Stats.NegativeHits.Add(1)
+		return ByteView{}, ErrNotFound
+	}
 	// 没查找到，调用load方法
-	return g.load(key)
+	value, err := g.load(ctx, key, forward)
+	// 数据源中已经没有这个 key，或者调用者已经放弃时不返回旧值
+	if err != nil && hasStale && !errors.Is(err, ErrNotFound) && ctx.Err() == nil {
+		g.Stats.StaleHits.Add(1)
+		log.Println("[Cache] Serving stale value after failed load", key, err)
+		return stale, nil
+	}
+	return value, err
+}
+
+// refresh 在后台重新加载 key，同一个 key 同时只有一个刷新，和前台的加载一样经过 singleFlight，
+// 已经有刷新在进行时返回 false
+func (g *Group) refresh(key string) bool {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return false
+	}
+	go func() {
+		defer g.refreshing.Delete(key)
+		// 没有调用者等待后台刷新，Getter panic 时只记录日志，不能结束整个进程
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("[Cache] Panic while refreshing", key, r)
+			}
+		}()
+		if _, err := g.load(context.Background(), key, true); err != nil {
+			log.Println("[Cache] Failed to refresh", key, err)
+		}
+	}()
+	return true
+}
+
+// Set 将缓存值写入到 key 所属的节点，过期时间使用 Group 默认的过期时间
+func (g *Group) Set(key string, value []byte) error {
+	return g.SetContext(context.Background(), key, value)
+}
+
+// SetContext 和 Set 一样，ctx 用于控制向远程节点写入的请求
+func (g *Group) SetContext(ctx context.Context, key string, value []byte) error {
+	if key == "" {
+		return errKeyRequired
+	}
+	view := ByteView{b: cloneBytes(value), e: g.expireAt(0)}
+	if replicas, self, ok := g.pickReplicas(key); ok {
+		// 写入所有副本，本节点不是副本时删除本机可能存在的旧值
+		if self {
+			g.populateCache(key, view)
+		} else {
+			g.removeLocally(key)
+			if len(replicas) == 0 {
+				return errNoReplicas
+			}
+		}
+		req := &pb.SetRequest{Group: g.name, Key: key, Value: view.b, Expire: expireToUnixNano(view.e)}
+		return forEachPeer(replicas, func(peer PeerGetter) error { return peer.Set(ctx, req) })
+	}
+	if g.peers != nil {
+		// key 属于远程节点时，通过 PeerGetter 写入到远程节点
+		if peer, ok := g.peers.PickPeer(key); ok {
+			req := &pb.SetRequest{Group: g.name, Key: key, Value: view.b, Expire: expireToUnixNano(view.e)}
+			if err := peer.Set(ctx, req); err != nil {
+				return err
+			}
+			// 本机可能因为之前从远程获取失败而缓存了旧值，一并删除
+			g.removeLocally(key)
+			return nil
+		}
+	}
+	g.populateCache(key, view)
+	return nil
+}
+
+// Remove 删除 key 所属节点上的缓存值，同时删除本机的缓存值
+func (g *Group) Remove(key string) error {
+	return g.RemoveContext(context.Background(), key)
+}
+
+// RemoveContext 和 Remove 一样，ctx 用于控制向远程节点删除的请求
+func (g *Group) RemoveContext(ctx context.Context, key string) error {
+	if key == "" {
+		return errKeyRequired
+	}
+	g.removeLocally(key)
+	if replicas, _, ok := g.pickReplicas(key); ok {
+		req := &pb.Request{Group: g.name, Key: key}
+		return forEachPeer(replicas, func(peer PeerGetter) error { return peer.Remove(ctx, req) })
+	}
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return peer.Remove(ctx, &pb.Request{Group: g.name, Key: key})
+		}
+	}
+	return nil
 }
 
-// load load 调用 getLocally（分布式场景下会调用 getFromPeer 从其他节点获取)
-func (g *Group) load(key string) (value ByteView, err error) {
-	// 使用 g.loader.Do 包裹请求保证相同的 key 只请求一次
-	signalFetch, err := g.loader.Do(key, func() (interface{}, error) {
+// Invalidate 让 key 在整个集群中失效，向所有节点广播删除请求
+// 除了 key 所属的节点，其他节点也可能因为从远程获取失败而回退到本地加载，保存了这个 key 的缓存值
+func (g *Group) Invalidate(key string) error {
+	return g.InvalidateContext(context.Background(), key)
+}
+
+// InvalidateContext 和 Invalidate 一样，ctx 用于控制向所有节点广播的删除请求
+func (g *Group) InvalidateContext(ctx context.Context, key string) error {
+	if key == "" {
+		return errKeyRequired
+	}
+	g.removeLocally(key)
+	if g.peers == nil {
+		return nil
+	}
+	req := &pb.Request{Group: g.name, Key: key}
+	return forEachPeer(g.peers.GetAll(), func(peer PeerGetter) error { return peer.Remove(ctx, req) })
+}
+
+// forEachPeer 并发地对所有节点调用 fn，等待所有节点返回，返回第一个遇到的错误
+func forEachPeer(peers []PeerGetter, fn func(peer PeerGetter) error) error {
+	errs := make(chan error, len(peers))
+	for _, peer := range peers {
+		go func(peer PeerGetter) {
+			errs <- fn(peer)
+		}(peer)
+	}
+	var err error
+	for range peers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// pickReplicas 开启 WithReplicatedWrites 并且 PeerPicker 实现了 ReplicaPicker 时返回 key 的副本，否则 ok 为 false
+func (g *Group) pickReplicas(key string) (replicas []PeerGetter, self bool, ok bool) {
+	if !g.replicateWrites || g.peers == nil {
+		return nil, false, false
+	}
+	rp, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return nil, false, false
+	}
+	replicas, self = rp.PickReplicas(key)
+	return replicas, self, true
+}
+
+// replicate 把从数据源加载的缓存值推送到其它副本，在后台执行，失败只记录日志
+func (g *Group) replicate(key string, value ByteView) {
+	replicas, self, ok := g.pickReplicas(key)
+	// 本节点不是副本时是因为远程节点失败才回退到本地加载，不推送
+	if !ok || !self || len(replicas) == 0 {
+		return
+	}
+	req := &pb.SetRequest{Group: g.name, Key: key, Value: value.b, Expire: expireToUnixNano(value.e)}
+	go func() {
+		if err := forEachPeer(replicas, func(peer PeerGetter) error { return peer.Set(context.Background(), req) }); err != nil {
+			log.Println("[Cache] Failed to replicate", key, err)
+		}
+	}()
+}
+
+// load load 调用 getLocally（分布式场景下会调用 getFromPeer 从其他节点获取)，forward 为 false 时只在本节点加载
+func (g *Group) load(ctx context.Context, key string, forward bool) (value ByteView, err error) {
+	g.Stats.Loads.Add(1)
+	// 使用 g.loader.DoDetached 包裹请求保证相同的 key 只请求一次，ctx 结束时不再等待。
+	// 加载使用与调用者分离的 ctx，第一个调用者取消或超时不会让其他等待的调用者和后台刷新失败
+	signalFetch, err, shared := g.loader.DoDetached(ctx, key, func(ctx context.Context) (interface{}, error) {
 		// 之前不能保证相同的 key 只 fetch 一次
 		if g.peers != nil {
-			// 使用 PickPeer() 方法选择节点，如果是非本机节点，则进入以下流程，调用 getFromPeer() 从远程获取
-			if peer, ok := g.peers.PickPeer(key); ok {
+			// 使用 PickPeer() 方法选择节点，如果是非本机节点，则进入以下流程，调用 getFromPeer() 从远程获取，
+			// 来自其它节点的请求不再转发
+			var peer PeerGetter
+			var ok bool
+			if forward {
+				peer, ok = g.peers.PickPeer(key)
+			}
+			if ok {
 				// 要从远程节点获取对应的 key 的缓存值， peer 是通过 key 查询到的远程节点的 URL
-				if value, err := g.getFromPeer(peer, key); err == nil {
+				value, err := g.getFromPeer(ctx, peer, key)
+				if err == nil {
+					g.Stats.PeerLoads.Add(1)
 					//log.Printf("[Cache] request key is from [%s]\n", peer)
+					// 抽样放入热点缓存，避免热点 key 每次都要向远程节点请求
+					if g.hotCacheRatio > 0 && !value.Stale() && rand.Intn(hotCacheSampling) == 0 {
+						g.hotCache.add(key, value)
+					}
 					return value, nil
 				}
-				// 若是本机节点或失败，则回退到 getLocally()
+				// key 所属的节点确认数据源中没有这个 key，不必再回退到数据源
+				if errors.Is(err, ErrNotFound) {
+					return nil, err
+				}
+				g.Stats.PeerErrors.Add(1)
 				log.Println("[Cache] Failed to get from peer", err)
+				// 请求不合法或者远程节点的数据源出错，回退到本地也会一样失败
+				if !retryLocally(err) {
+					return nil, err
+				}
+				// 远程节点故障、过载或超时，回退到 getLocally()
+				// 调用者已经取消或超时，不必再回退到数据源
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+			} else if value, ok := g.getFromPrevious(ctx, key); ok {
+				// key 属于本节点，但缓存值可能还在原来所属的节点上，没有迁移过来
+				return value, nil
 			}
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
+	// 加入了其它调用者正在进行的加载，没有自己加载
+	if shared {
+		g.Stats.LoadsDeduped.Add(1)
+	}
 	if err == nil {
 		return signalFetch.(ByteView), nil
 	}
 	return
 }
 
+// getFromPrevious 节点变化后的迁移期间，从 key 原来所属的节点获取已经缓存的值并保存到 mainCache，
+// 原来的节点没有缓存或者请求失败时返回 false，由调用者回退到数据源
+func (g *Group) getFromPrevious(ctx context.Context, key string) (ByteView, bool) {
+	hp, ok := g.peers.(HandoffPicker)
+	if !ok {
+		return ByteView{}, false
+	}
+	peer, ok := hp.PickPrevious(g.name, key)
+	if !ok {
+		return ByteView{}, false
+	}
+	value, err := g.getFromPeer(ctx, peer, key)
+	if err != nil {
+		return ByteView{}, false
+	}
+	g.populateCache(key, value)
+	// 获取期间 key 被删除了，原来的 owner 上的是旧值，不能保存
+	if _, ok := hp.PickPrevious(g.name, key); !ok {
+		g.mainCache.remove(key)
+		return ByteView{}, false
+	}
+	g.Stats.HandoffLoads.Add(1)
+	return value, true
+}
+
 // getLocally 调用用户回调函数 g.getter.Get() 获取源数据
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	start := time.Now()
+	bytes, ttl, err := g.getFromSource(ctx, key)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			g.cacheNotFound(key)
+		}
+		g.Stats.LocalLoadErrs.Add(1)
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
+	g.Stats.LocalLoads.Add(1)
+	value := ByteView{b: cloneBytes(bytes), e: g.expireAt(ttl), d: time.Since(start)}
 	// 通过 populateCache 方法将源数据添加到缓存 mainCache 中
 	g.populateCache(key, value)
+	g.replicate(key, value)
 	return value, nil
 }
 
-// populateCache 将源数据添加到缓存 mainCache 中
+// getFromSource 调用用户回调函数获取源数据，getter 实现了 GetterCtx 时传入 ctx，
+// 实现了 TTLGetter 时同时获取 key 的过期时间。过期时间决定 stale 窗口和提前刷新的时机，
+// 同时实现了 GetterCtx 和 TTLGetter 的数据源优先使用 TTLGetter，两者都需要时应该实现 TTLGetterCtx
+func (g *Group) getFromSource(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	if tg, ok := g.getter.(TTLGetterCtx); ok {
+		return tg.GetContextWithTTL(ctx, key)
+	}
+	if tg, ok := g.getter.(TTLGetter); ok {
+		return tg.GetWithTTL(key)
+	}
+	if cg, ok := g.getter.(GetterCtx); ok {
+		bytes, err := cg.GetContext(ctx, key)
+		return bytes, 0, err
+	}
+	bytes, err := g.getter.Get(key)
+	return bytes, 0, err
+}
+
+// expireAt 根据 key 的过期时间计算过期时刻，ttl 为 0 时使用 Group 的默认过期时间，都为 0 时永不过期
+func (g *Group) expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// cacheNotFound 在 negativeTTL 内记住 key 不存在，只保存 key，占用的空间不超过 cacheBytes 的 negativeRatio
+func (g *Group) cacheNotFound(key string) {
+	if g.negativeTTL <= 0 || g.negativeRatio <= 0 {
+		return
+	}
+	g.negCache.add(key, ByteView{e: time.Now().Add(g.negativeTTL)})
+}
+
+// populateCache 将源数据添加到缓存 mainCache 中，key 已经存在，不再记为不存在
 func (g *Group) populateCache(key string, value ByteView) {
 	g.mainCache.add(key, value)
+	g.negCache.remove(key)
+}
+
+// removeLocally 只删除本机的缓存值，包括热点缓存和不存在的记录，
+// 节点变化后的迁移期间先记下 key 已被删除，原来的 owner 上的旧值不会再被获取或迁移过来
+func (g *Group) removeLocally(key string) {
+	if hp, ok := g.peers.(HandoffPicker); ok {
+		hp.ForgetPrevious(g.name, key)
+	}
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.negCache.remove(key)
 }
 
 // RegisterPeers 实现了 PeerPicker 接口的 HTTPPool 注入到 Group 中
@@ -137,7 +581,7 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 }
 
 // getFromPeer 使用实现了 PeerGetter 接口的 httpGetter 从访问远程节点，获取缓存值
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	// 使用 protoc 通信
 	req := &pb.Request{
 		// name 是缓存的名字，key 是这个缓存中这个 key 的值
@@ -145,9 +589,27 @@ func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	start := time.Now()
+	err := peer.Get(ctx, req, res)
+	g.peerLatency.observe(time.Since(start))
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, err
+	return ByteView{b: res.Value, e: expireFromUnixNano(res.Expire), s: res.Stale, d: time.Duration(res.Delta)}, err
+}
+
+// expireToUnixNano 把过期时间转换为 UnixNano 以便在节点间传输，零值表示永不过期
+func expireToUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// expireFromUnixNano 是 expireToUnixNano 的逆过程
+func expireFromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
 }
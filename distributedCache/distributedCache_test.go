@@ -1,10 +1,18 @@
 package distributedCache
 
 import (
+	"context"
+	"distributedCache/eviction"
+	"distributedCache/pb"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // 用一个 map 模拟耗时的数据库
@@ -56,3 +64,627 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGetExpire(t *testing.T) {
+	loads := 0
+	g := NewGroup("ttl", 2<<10, TTLGetterFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			loads++
+			// key "short" 单独指定一个更短的过期时间，其余的使用 Group 默认的过期时间
+			if key == "short" {
+				return []byte(key), 10 * time.Millisecond, nil
+			}
+			return []byte(key), 0, nil
+		}), WithTTL(time.Hour))
+
+	for _, key := range []string{"short", "long"} {
+		if _, err := g.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	// short 已经过期需要重新加载，long 仍然命中缓存
+	for _, key := range []string{"short", "long"} {
+		if _, err := g.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads != 3 {
+		t.Fatalf("expect 3 loads, got %d", loads)
+	}
+}
+
+// ctxTTLGetter 同时实现了 GetterCtx 和 TTLGetter 的数据源
+type ctxTTLGetter struct{}
+
+func (ctxTTLGetter) Get(key string) ([]byte, error) { return []byte(key), nil }
+func (ctxTTLGetter) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return []byte(key), nil
+}
+func (ctxTTLGetter) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return []byte(key), time.Minute, nil
+}
+
+func TestGetExpireContext(t *testing.T) {
+	getters := map[string]Getter{
+		"ttl-ctx-func": TTLGetterCtxFunc(func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			return []byte(key), time.Minute, nil
+		}),
+		"ttl-ctx-both": ctxTTLGetter{},
+	}
+	for name, getter := range getters {
+		g := NewGroup(name, 2<<10, getter, WithTTL(time.Hour))
+		if _, err := g.GetContext(context.Background(), "key"); err != nil {
+			t.Fatal(err)
+		}
+		// 数据源指定的过期时间不能被 Group 默认的过期时间覆盖
+		view, ok := g.mainCache.peek("key")
+		if left := time.Until(view.e); !ok || left > time.Minute || left < 50*time.Second {
+			t.Fatalf("%s: entry expires in %v, expect the getter's ttl", name, left)
+		}
+	}
+}
+
+// fakePeer 记录收到的写入和删除请求
+type fakePeer struct {
+	mu      sync.Mutex
+	sets    map[string]string
+	removes []string
+	gets    int
+}
+
+func (f *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	out.Value = []byte(f.sets[in.Key])
+	return nil
+}
+
+func (f *fakePeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sets[in.Key] = string(in.Value)
+	return nil
+}
+
+func (f *fakePeer) Remove(ctx context.Context, in *pb.Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removes = append(f.removes, in.Key)
+	return nil
+}
+
+// fakePicker 以 remote 开头的 key 属于远程节点 owner，other 是集群中的另一个节点
+type fakePicker struct {
+	owner, other *fakePeer
+}
+
+func (f *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if len(key) >= 6 && key[:6] == "remote" {
+		return f.owner, true
+	}
+	return nil, false
+}
+
+func (f *fakePicker) GetAll() []PeerGetter {
+	return []PeerGetter{f.owner, f.other}
+}
+
+func TestSetRemoveInvalidate(t *testing.T) {
+	picker := &fakePicker{
+		owner: &fakePeer{sets: map[string]string{}},
+		other: &fakePeer{sets: map[string]string{}},
+	}
+	g := NewGroup("writes", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	g.RegisterPeers(picker)
+
+	// 本机的 key 直接写入 mainCache
+	if err := g.Set("local", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get("local"); err != nil || v.String() != "v1" {
+		t.Fatalf("local set failed: %v %v", v, err)
+	}
+	// 远程的 key 写入到所属节点
+	if err := g.Set("remote", []byte("v2")); err != nil || picker.owner.sets["remote"] != "v2" {
+		t.Fatalf("remote set failed: %v", err)
+	}
+	if _, ok := g.mainCache.find("remote"); ok {
+		t.Fatalf("remote key should not be cached locally")
+	}
+
+	if err := g.Remove("local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("local"); err == nil {
+		t.Fatalf("local key should be removed")
+	}
+	if err := g.Remove("remote"); err != nil || !reflect.DeepEqual(picker.owner.removes, []string{"remote"}) {
+		t.Fatalf("remote remove failed: %v", picker.owner.removes)
+	}
+
+	// Invalidate 向所有节点广播
+	if err := g.Invalidate("remote"); err != nil {
+		t.Fatal(err)
+	}
+	if len(picker.owner.removes) != 2 || len(picker.other.removes) != 1 {
+		t.Fatalf("invalidate should reach every peer: %v %v", picker.owner.removes, picker.other.removes)
+	}
+}
+
+// fakeReplicaPicker 以 remote 开头的 key 保存在 owner 和 other 上，其余的 key 保存在本节点和 other 上
+type fakeReplicaPicker struct {
+	*fakePicker
+}
+
+func (f *fakeReplicaPicker) PickReplicas(key string) ([]PeerGetter, bool) {
+	if _, ok := f.PickPeer(key); ok {
+		return []PeerGetter{f.owner, f.other}, false
+	}
+	return []PeerGetter{f.other}, true
+}
+
+func (f *fakePeer) value(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.sets[key]
+	return v, ok
+}
+
+// noReplicaPicker 所有副本都不可用
+type noReplicaPicker struct {
+	*fakePicker
+}
+
+func (f *noReplicaPicker) PickReplicas(key string) ([]PeerGetter, bool) {
+	return nil, false
+}
+
+func TestReplicatedWritesNoReplicas(t *testing.T) {
+	g := NewGroup("noReplicas", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}), WithReplicatedWrites())
+	g.RegisterPeers(&noReplicaPicker{&fakePicker{owner: &fakePeer{}, other: &fakePeer{}}})
+	// 没有任何节点保存这次写入，调用者需要知道写入失败了
+	if err := g.Set("remote", []byte("v")); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expect ErrOverloaded, got %v", err)
+	}
+	if _, ok := g.mainCache.find("remote"); ok {
+		t.Fatalf("value should not be cached locally")
+	}
+}
+
+func TestReplicatedWrites(t *testing.T) {
+	picker := &fakeReplicaPicker{&fakePicker{
+		owner: &fakePeer{sets: map[string]string{}},
+		other: &fakePeer{sets: map[string]string{}},
+	}}
+	g := NewGroup("replicated", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}), WithReplicatedWrites())
+	g.RegisterPeers(picker)
+
+	// 本节点是副本时写入本机，同时推送到其它副本
+	if err := g.Set("local", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mainCache.find("local"); !ok {
+		t.Fatalf("local replica not written")
+	}
+	if v, _ := picker.other.value("local"); v != "v1" {
+		t.Fatalf("other replica got %q", v)
+	}
+	// 本节点不是副本时只写入远程的副本
+	if err := g.Set("remote", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if v1, _ := picker.owner.value("remote"); v1 != "v2" {
+		t.Fatalf("owner replica got %q", v1)
+	}
+	if v2, _ := picker.other.value("remote"); v2 != "v2" {
+		t.Fatalf("other replica got %q", v2)
+	}
+	if _, ok := g.mainCache.find("remote"); ok {
+		t.Fatalf("non-replica should not keep the value")
+	}
+	// 删除同样发送到所有副本
+	if err := g.Remove("remote"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(picker.owner.removes, []string{"remote"}) || !reflect.DeepEqual(picker.other.removes, []string{"remote"}) {
+		t.Fatalf("removes not replicated: %v %v", picker.owner.removes, picker.other.removes)
+	}
+
+	// 从数据源加载的缓存值在后台推送到其它副本
+	if v, err := g.Get("fresh"); err != nil || v.String() != "db-fresh" {
+		t.Fatalf("get failed: %v %v", v, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := picker.other.value("fresh"); v == "db-fresh" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("loaded value not pushed to other replica")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	picker := &fakePicker{
+		owner: &fakePeer{sets: map[string]string{"remote": "v"}},
+		other: &fakePeer{sets: map[string]string{}},
+	}
+	g := NewGroup("hot", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	g.RegisterPeers(picker)
+
+	for i := 0; i < 200; i++ {
+		if v, err := g.Get("remote"); err != nil || v.String() != "v" {
+			t.Fatalf("get remote failed: %v %v", v, err)
+		}
+	}
+	// 抽样放入热点缓存后，后续的请求不再访问远程节点
+	if picker.owner.gets >= 200 {
+		t.Fatalf("hot cache never hit, %d peer gets", picker.owner.gets)
+	}
+	if _, ok := g.mainCache.find("remote"); ok {
+		t.Fatalf("peer value should not be stored in mainCache")
+	}
+	// 删除时热点缓存也要一起删除
+	g.removeLocally("remote")
+	if _, ok := g.hotCache.find("remote"); ok {
+		t.Fatalf("hot cache should be cleared by removeLocally")
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	var maxBytes []int64
+	newPolicy := func(max int64, onEvicted func(string, eviction.Value)) eviction.Policy {
+		maxBytes = append(maxBytes, max)
+		return LRUPolicy(max, onEvicted)
+	}
+	g := NewGroup("policy", 800, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithPolicy(newPolicy))
+
+	g.mainCache.add("k", ByteView{b: []byte("v")})
+	g.hotCache.add("k", ByteView{b: []byte("v")})
+	g.negCache.add("k", ByteView{})
+	// 三个缓存都使用传入的淘汰策略，并按照比例划分 cacheBytes
+	if !reflect.DeepEqual(maxBytes, []int64{650, 100, 50}) {
+		t.Fatalf("policy created with %v", maxBytes)
+	}
+}
+
+func TestZeroCacheShare(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	// cacheBytes 太小时热点缓存和不存在的 key 分到的空间舍入为 0，不能变成不限制
+	small := NewGroup("zeroShare", 5, getter)
+	ratioOff := NewGroup("zeroHotRatio", 800, getter, WithHotCacheRatio(0), WithNegativeCacheRatio(0))
+	for _, g := range []*Group{small, ratioOff} {
+		for i := 0; i < 100; i++ {
+			g.hotCache.add(strconv.Itoa(i), ByteView{b: []byte("v")})
+			g.negCache.add(strconv.Itoa(i), ByteView{})
+		}
+		if hot, neg := g.CacheStats(HotCache), g.CacheStats(NegativeCache); hot.Items != 0 || neg.Items != 0 {
+			t.Fatalf("%s: hot items = %d, negative items = %d, expect both disabled", g.name, hot.Items, neg.Items)
+		}
+	}
+	// cacheBytes 为 0 时三者都不限制
+	unlimited := NewGroup("zeroBytes", 0, getter)
+	unlimited.hotCache.add("k", ByteView{b: []byte("v")})
+	if unlimited.CacheStats(HotCache).Items != 1 {
+		t.Fatal("hot cache disabled for unlimited cacheBytes")
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	g := NewGroup("ctx", 2<<10, GetterCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		// 模拟一个很慢的数据源，直到 ctx 结束
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.GetContext(ctx, "slow"); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("GetContext did not return at the deadline")
+	}
+}
+
+func TestGetContextLeaderCanceled(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var loads int32
+	g := NewGroup("leaderCanceled", 2<<10, GetterCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		close(started)
+		select {
+		case <-release:
+			return []byte("v"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	leader, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(leader, "k")
+		errc <- err
+	}()
+	<-started
+	// 第二个调用者合并到第一个调用者发起的加载中
+	type result struct {
+		v   ByteView
+		err error
+	}
+	waiter := make(chan result, 1)
+	go func() {
+		v, err := g.GetContext(context.Background(), "k")
+		waiter <- result{v, err}
+	}()
+	waitUntil(t, func() bool { return g.Stats.Loads.Get() == 2 })
+	// 第一个调用者取消之后，加载继续进行，第二个调用者仍然拿到结果
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("leader got %v, expect Canceled", err)
+	}
+	close(release)
+	if r := <-waiter; r.err != nil || r.v.String() != "v" {
+		t.Fatalf("waiter got %v, %v", r.v, r.err)
+	}
+	if loads != 1 {
+		t.Fatalf("loads = %d, expect 1", loads)
+	}
+}
+
+func TestStats(t *testing.T) {
+	g := NewGroup("stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	g.Get("Tom")
+	g.Get("Tom")
+	g.Get("unknown")
+
+	if g.Stats.Gets.Get() != 3 || g.Stats.CacheHits.Get() != 1 || g.Stats.Loads.Get() != 2 {
+		t.Fatalf("gets=%v hits=%v loads=%v", &g.Stats.Gets, &g.Stats.CacheHits, &g.Stats.Loads)
+	}
+	if g.Stats.LocalLoads.Get() != 1 || g.Stats.LocalLoadErrs.Get() != 1 {
+		t.Fatalf("localLoads=%v localLoadErrs=%v", &g.Stats.LocalLoads, &g.Stats.LocalLoadErrs)
+	}
+	expect := CacheStats{Bytes: int64(len("Tom") + len("630")), Items: 1, Gets: 3, Hits: 1}
+	if cs := g.CacheStats(MainCache); cs != expect {
+		t.Fatalf("CacheStats = %+v, expect %+v", cs, expect)
+	}
+	// 主动删除不算作淘汰
+	g.Remove("Tom")
+	if cs := g.CacheStats(MainCache); cs.Items != 0 || cs.Evictions != 0 {
+		t.Fatalf("CacheStats after remove = %+v", cs)
+	}
+}
+
+func TestStatsLoadsDeduped(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("statsDeduped", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Get("key")
+		}()
+	}
+	for g.Stats.Loads.Get() != 5 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// 5 个调用者只加载了一次，其余 4 个等待这次加载的结果
+	if g.Stats.LocalLoads.Get() != 1 || g.Stats.LoadsDeduped.Get() != 4 {
+		t.Fatalf("localLoads=%v loadsDeduped=%v", &g.Stats.LocalLoads, &g.Stats.LoadsDeduped)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	var loads int32
+	g := NewGroup("negative", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		if key == "error" {
+			return nil, fmt.Errorf("db is down")
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}), WithNegativeTTL(50*time.Millisecond))
+
+	// 第二次请求命中不存在的记录，不再访问数据源
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get = %v, expect ErrNotFound", err)
+		}
+	}
+	if loads != 1 || g.Stats.NegativeHits.Get() != 1 {
+		t.Fatalf("loads = %d, negative hits = %v", loads, &g.Stats.NegativeHits)
+	}
+	// 其它错误不缓存
+	g.Get("error")
+	g.Get("error")
+	if loads != 3 {
+		t.Fatalf("loads = %d, other errors should not be cached", loads)
+	}
+	// 过期之后重新访问数据源
+	time.Sleep(60 * time.Millisecond)
+	g.Get("missing")
+	if loads != 4 {
+		t.Fatalf("loads = %d after negative ttl", loads)
+	}
+	// 写入之后 key 存在了
+	g.Set("missing", []byte("v"))
+	if v, err := g.Get("missing"); err != nil || v.String() != "v" {
+		t.Fatalf("Get after Set = %v, %v", v, err)
+	}
+	if cs := g.CacheStats(NegativeCache); cs.Items != 0 {
+		t.Fatalf("negative cache stats after Set = %+v", cs)
+	}
+}
+
+// notFoundPicker 所有 key 都属于一个总是返回 ErrNotFound 的远程节点
+type notFoundPicker struct{}
+
+func (p notFoundPicker) PickPeer(key string) (PeerGetter, bool) { return p, true }
+func (p notFoundPicker) GetAll() []PeerGetter                   { return nil }
+func (p notFoundPicker) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return ErrNotFound
+}
+func (p notFoundPicker) Set(ctx context.Context, in *pb.SetRequest) error { return nil }
+func (p notFoundPicker) Remove(ctx context.Context, in *pb.Request) error { return nil }
+
+func TestPeerNotFound(t *testing.T) {
+	var loads int32
+	g := NewGroup("peerNotFound", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("local"), nil
+	}))
+	g.RegisterPeers(notFoundPicker{})
+	// 远程节点确认 key 不存在时，不回退到本地的数据源
+	if _, err := g.Get("k"); !errors.Is(err, ErrNotFound) || loads != 0 || g.Stats.PeerErrors.Get() != 0 {
+		t.Fatalf("Get = %v, loads = %d, peer errors = %v", err, loads, &g.Stats.PeerErrors)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	g := NewGroup("staleWhileRevalidate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&loads, 1)
+		if n > 1 {
+			// 后台刷新很慢，调用者不应该等待它
+			<-release
+		}
+		return []byte(fmt.Sprintf("v%d", n)), nil
+	}), WithTTL(30*time.Millisecond), WithStaleWhileRevalidate(time.Second))
+
+	if v, err := g.Get("k"); err != nil || v.String() != "v1" || v.Stale() {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	// 过期之后立即返回旧值，多次请求只触发一次刷新
+	for i := 0; i < 3; i++ {
+		if v, err := g.Get("k"); err != nil || v.String() != "v1" || !v.Stale() {
+			t.Fatalf("Get = %v (stale %v), %v, expect stale v1", v, v.Stale(), err)
+		}
+	}
+	close(release)
+	waitUntil(t, func() bool {
+		v, err := g.Get("k")
+		return err == nil && v.String() == "v2" && !v.Stale()
+	})
+	if atomic.LoadInt32(&loads) != 2 || g.Stats.StaleHits.Get() < 3 {
+		t.Fatalf("loads = %d, stale hits = %v", loads, &g.Stats.StaleHits)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	newGroup := func(name string, loads *int32, opts ...GroupOption) *Group {
+		return NewGroup(name, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			n := atomic.AddInt32(loads, 1)
+			time.Sleep(10 * time.Millisecond)
+			return []byte(fmt.Sprintf("v%d", n)), nil
+		}), append(opts, WithTTL(time.Minute))...)
+	}
+	// 离过期还很远，beta 为 1 时几乎不可能提前刷新；不开启时从不提前刷新
+	for name, opts := range map[string][]GroupOption{
+		"earlyRefreshFar": {WithEarlyRefresh(1)},
+		"earlyRefreshOff": nil,
+	} {
+		var loads int32
+		g := newGroup(name, &loads, opts...)
+		for i := 0; i < 100; i++ {
+			if v, err := g.Get("k"); err != nil || v.String() != "v1" {
+				t.Fatalf("%s: Get = %v, %v", name, v, err)
+			}
+		}
+		if atomic.LoadInt32(&loads) != 1 || g.Stats.EarlyRefreshes.Get() != 0 {
+			t.Fatalf("%s: loads = %d, early refreshes = %v", name, loads, &g.Stats.EarlyRefreshes)
+		}
+	}
+
+	// beta 足够大时加载耗时乘以 beta 远超 TTL，下一次命中一定提前刷新，调用者仍然拿到未过期的旧值
+	var loads int32
+	g := newGroup("earlyRefreshNear", &loads, WithEarlyRefresh(1e9))
+	g.Get("k")
+	if v, err := g.Get("k"); err != nil || v.String() != "v1" || v.Stale() {
+		t.Fatalf("Get = %v, %v, expect fresh v1", v, err)
+	}
+	waitUntil(t, func() bool {
+		v, err := g.Get("k")
+		return err == nil && v.String() != "v1"
+	})
+	if g.Stats.EarlyRefreshes.Get() == 0 || g.Stats.StaleHits.Get() != 0 {
+		t.Fatalf("early refreshes = %v, stale hits = %v", &g.Stats.EarlyRefreshes, &g.Stats.StaleHits)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var mu sync.Mutex
+	var failure error
+	setFailure := func(err error) {
+		mu.Lock()
+		failure = err
+		mu.Unlock()
+	}
+	g := NewGroup("staleIfError", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failure != nil {
+			return nil, failure
+		}
+		return []byte("v"), nil
+	}), WithTTL(20*time.Millisecond), WithStaleIfError(200*time.Millisecond))
+
+	g.Get("k")
+	g.Get("gone")
+	setFailure(errors.New("db is down"))
+	time.Sleep(30 * time.Millisecond)
+	// 数据源出错时返回过期的旧值
+	if v, err := g.Get("k"); err != nil || v.String() != "v" || !v.Stale() {
+		t.Fatalf("Get = %v, %v, expect stale v", v, err)
+	}
+	// 数据源中已经没有这个 key 时不返回旧值
+	setFailure(ErrNotFound)
+	if _, err := g.Get("gone"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get = %v, expect ErrNotFound", err)
+	}
+	// 超过 grace 之后返回错误
+	setFailure(errors.New("db is down"))
+	time.Sleep(200 * time.Millisecond)
+	if _, err := g.Get("k"); err == nil {
+		t.Fatal("stale value served after the grace period")
+	}
+}
+
+// waitUntil 等待 cond 成立，最多等待 5 秒
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,99 @@
+package distributedCache
+
+import (
+	"context"
+	"distributedCache/circuitBreaker"
+	"distributedCache/pb"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 节点间传递有类型的错误：服务端把错误转换为 pb.Code 放在响应体中，客户端再还原为 PeerError，
+// 调用者通过 errors.Is 判断错误的类别，Group.load 据此决定是否回退到本地的数据源
+
+var (
+	// ErrNotFound Getter 在数据源中找不到 key 时返回 ErrNotFound，或者用 fmt.Errorf 的 %w 包装它，
+	// Group 会在一段较短的时间内缓存这个结果，期间对这个 key 的请求直接返回 ErrNotFound，不再访问数据源
+	ErrNotFound = errors.New("distributedCache: key not found")
+	// ErrBadRequest 请求不合法，例如 key 为空，换一个节点也不会成功
+	ErrBadRequest = errors.New("distributedCache: bad request")
+	// ErrOverloaded 节点过载或者断路器打开，换一个节点或者回退到本地可能成功
+	ErrOverloaded = errors.New("distributedCache: overloaded")
+	// ErrTimeout 远程节点在截止时间之前没有完成
+	ErrTimeout = errors.New("distributedCache: timeout")
+	// ErrInternal 远程节点的数据源返回了其它错误
+	ErrInternal = errors.New("distributedCache: internal error")
+)
+
+// errKeyRequired Get、Set 等方法的 key 为空
+var errKeyRequired = fmt.Errorf("%w: key is required", ErrBadRequest)
+
+// errNoReplicas 开启副本写入时 key 的副本都不可用，本节点也不是副本，写入没有保存到任何节点
+var errNoReplicas = fmt.Errorf("%w: no available replicas", ErrOverloaded)
+
+// codeErrors pb.Code 对应的错误
+var codeErrors = map[pb.Code]error{
+	pb.Code_NOT_FOUND:   ErrNotFound,
+	pb.Code_BAD_REQUEST: ErrBadRequest,
+	pb.Code_OVERLOADED:  ErrOverloaded,
+	pb.Code_TIMEOUT:     ErrTimeout,
+	pb.Code_INTERNAL:    ErrInternal,
+}
+
+// PeerError 远程节点返回的有类型的错误，errors.Is 可以和 ErrNotFound、ErrOverloaded 等错误比较
+type PeerError struct {
+	Peer    string  // 远程节点的地址
+	Code    pb.Code // 错误的类别
+	Message string  // 远程节点的错误信息
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer %s: %s: %s", e.Peer, e.Code, e.Message)
+}
+
+// Is 让 errors.Is(err, ErrNotFound) 等判断对 PeerError 成立
+func (e *PeerError) Is(target error) bool {
+	return codeErrors[e.Code] == target
+}
+
+// errorCode 服务端把 Group 返回的错误转换为 pb.Code，下游节点返回的 PeerError 保留原来的类别
+func errorCode(err error) pb.Code {
+	var pe *PeerError
+	switch {
+	case errors.As(err, &pe):
+		return pe.Code
+	case errors.Is(err, ErrNotFound):
+		return pb.Code_NOT_FOUND
+	case errors.Is(err, ErrBadRequest):
+		return pb.Code_BAD_REQUEST
+	case errors.Is(err, ErrOverloaded), errors.Is(err, circuitBreaker.ErrOpen), errors.Is(err, circuitBreaker.ErrTooManyRequests):
+		return pb.Code_OVERLOADED
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return pb.Code_TIMEOUT
+	default:
+		return pb.Code_INTERNAL
+	}
+}
+
+// httpStatus pb.Code 对应的 HTTP 状态码，健康检查和断路器仍然按照状态码判断远程节点是否正常
+func httpStatus(code pb.Code) int {
+	switch code {
+	case pb.Code_NOT_FOUND:
+		return http.StatusNotFound
+	case pb.Code_BAD_REQUEST:
+		return http.StatusBadRequest
+	case pb.Code_OVERLOADED:
+		return http.StatusServiceUnavailable
+	case pb.Code_TIMEOUT:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// retryLocally 从远程节点获取失败后是否回退到本地的数据源：key 不存在、请求不合法和远程数据源的错误在本地也会一样，
+// 连接失败、节点过载和超时说明的是远程节点的问题，回退到本地可能成功
+func retryLocally(err error) bool {
+	return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrBadRequest) && !errors.Is(err, ErrInternal)
+}
@@ -0,0 +1,27 @@
+package eviction
+
+import "time"
+
+// 抽象缓存淘汰策略，cache 只依赖 Policy 接口，不同的 Group 可以选择不同的淘汰策略
+
+// Value 为了通用性，值是实现了 Value 接口的任意类型，该接口只包含了一个方法 Len() int，用于返回值所占用的内存大小
+type Value interface {
+	Len() int
+}
+
+// Policy 淘汰策略接口，按照字节数计算内存占用，并发访问是不安全的，由上层的 cache 负责加锁
+type Policy interface {
+	Add(key string, value Value)                             // 新增一个永不过期的节点，超过最大内存时按照策略淘汰
+	AddWithExpire(key string, value Value, expire time.Time) // 新增一个节点，expire 为零值表示永不过期
+	Find(key string) (value Value, ok bool)                  // 查找节点，过期的节点视为未命中
+	Peek(key string) (value Value, ok bool)                  // 和 Find 一样查找节点，但是不影响淘汰顺序和访问频率，也不删除过期的节点
+	Delete(key string) bool                                  // 删除指定 key 的节点，返回节点是否存在
+	Remove()                                                 // 按照策略淘汰一个节点
+	RemoveExpired() int                                      // 移除所有已经过期的节点，返回移除的数量
+	Bytes() int64                                            // 当前已使用的内存
+	GetRecord() int                                          // 当前保存的节点数量
+	Keys() []string                                          // 所有没有过期的节点的 key，不影响淘汰顺序
+}
+
+// Factory 淘汰策略的构造函数，maxBytes 为 0 表示不限制内存，onEvicted 是节点被淘汰或过期移除时的回调函数
+type Factory func(maxBytes int64, onEvicted func(key string, value Value)) Policy
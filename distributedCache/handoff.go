@@ -0,0 +1,328 @@
+package distributedCache
+
+import (
+	"bytes"
+	"context"
+	"distributedCache/consistentHash"
+	"distributedCache/pb"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// 节点变化时迁移缓存值：比较新旧两个哈希环，找出归属变成本节点的区间，在后台按照限速从原来的 owner 拉取这些区间内的缓存值，
+// 迁移完成之前，本节点未命中的 key 先向原来的 owner 获取，而不是全部落到数据源上。只支持一致性哈希环 consistentHash.Map
+
+// handoffPath 迁移时查询 key 的路径，完整的地址是 basePath + handoffPath，例如 /_cache/handoff
+const handoffPath = "handoff"
+
+// peekHeader 请求头中带有 peekHeader 的 GET 请求只读取远程节点 mainCache 中已有的缓存值，没有时返回 404，不会加载
+const peekHeader = "X-Cache-Peek"
+
+// handoff 从一个原来的 owner 迁移缓存值
+type handoff struct {
+	from    string                 // 原来的 owner
+	client  *httpClient            // 只读取原来的 owner 已有缓存值的客户端
+	ranges  []consistentHash.Range // 从 from 移动到本节点的区间
+	removed map[groupKey]struct{}  // 迁移期间在本节点被删除的 key，不再从 from 获取，由 p.mu 保护
+}
+
+// groupKey 一个 Group 中的 key
+type groupKey struct {
+	group, key string
+}
+
+// contains key 的哈希值是否落在迁移的区间内
+func (h *handoff) contains(hash uint32) bool {
+	for _, r := range h.ranges {
+		if r.Contains(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// setRing 替换节点选择策略，开启迁移时在后台从原来的 owner 迁移归属发生变化的 key，调用者需要持有 p.mu 的写锁
+func (p *HTTPPool) setRing(ring PeerSelector) {
+	old := p.peers
+	p.peers = ring
+	if p.handoffRate > 0 && old != nil {
+		p.startHandoff(old, ring)
+	}
+}
+
+// rebuildRing 用当前所有节点创建一个新的节点选择策略，迁移需要保留旧的哈希环，不能原地修改，调用者需要持有 p.mu
+func (p *HTTPPool) rebuildRing() PeerSelector {
+	addrs := make([]string, 0, len(p.httpClients))
+	for addr := range p.httpClients {
+		addrs = append(addrs, addr)
+	}
+	ring := p.newSelector()
+	p.addToRing(ring, addrs)
+	return ring
+}
+
+// startHandoff 计算从 old 变为 ring 时移动到本节点的区间，按原来的 owner 分组后在后台迁移
+// 哈希环再次变化时放弃还没有完成的迁移，重新计算，调用者需要持有 p.mu 的写锁
+func (p *HTTPPool) startHandoff(old, ring PeerSelector) {
+	oldRing, ok := old.(*consistentHash.Map)
+	if !ok {
+		return
+	}
+	newRing, ok := ring.(*consistentHash.Map)
+	if !ok {
+		return
+	}
+	ranges := consistentHash.Diff(oldRing, newRing)
+	// 节点没有变化，例如 Set 重复设置了相同的节点，继续原来的迁移
+	if len(ranges) == 0 {
+		return
+	}
+	if p.handoffCancel != nil {
+		p.handoffCancel()
+		p.handoffCancel = nil
+	}
+	p.handoffs = nil
+	byOwner := make(map[string]*handoff)
+	for _, r := range ranges {
+		if r.To != p.self || r.From == "" || r.From == p.self {
+			continue
+		}
+		h, ok := byOwner[r.From]
+		if !ok {
+			h = &handoff{
+				from:    r.From,
+				client:  &httpClient{baseUrl: r.From + p.basePath, timeout: p.timeout, peek: true},
+				removed: make(map[groupKey]struct{}),
+			}
+			byOwner[r.From] = h
+			p.handoffs = append(p.handoffs, h)
+		}
+		h.ranges = append(h.ranges, r)
+	}
+	if len(p.handoffs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.handoffCancel = cancel
+	go p.runHandoffs(ctx, p.handoffs)
+}
+
+// runHandoffs 依次从每个原来的 owner 迁移，所有 owner 共用一个限速器，每秒最多拉取 handoffRate 个 key
+func (p *HTTPPool) runHandoffs(ctx context.Context, handoffs []*handoff) {
+	limiter := time.NewTicker(time.Second / time.Duration(p.handoffRate))
+	defer limiter.Stop()
+	for _, h := range handoffs {
+		p.pull(ctx, h, limiter.C)
+		if ctx.Err() != nil {
+			return
+		}
+		p.finishHandoff(h)
+	}
+}
+
+// pull 从原来的 owner 拉取所有使用本 HTTPPool 的 Group 中落在迁移区间内的缓存值，
+// 已经存在的 key 不会被覆盖，原来的 owner 上已经被淘汰的 key 和迁移期间被删除的 key 直接跳过
+func (p *HTTPPool) pull(ctx context.Context, h *handoff, tick <-chan time.Time) {
+	for _, g := range groupsWith(p) {
+		keys, err := h.client.handoffKeys(ctx, g.name, h.ranges)
+		if err != nil {
+			if ctx.Err() == nil {
+				p.Log("handoff from %s failed: %v", h.from, err)
+			}
+			return
+		}
+		p.Log("handoff %d keys of group %s from %s", len(keys), g.name, h.from)
+		for _, key := range keys {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return
+			}
+			if p.forgotten(h, g.name, key) {
+				continue
+			}
+			res := &pb.Response{}
+			if err := h.client.Get(ctx, &pb.Request{Group: g.name, Key: key}, res); err != nil {
+				continue
+			}
+			if !g.mainCache.addIfAbsent(key, ByteView{b: res.Value, e: expireFromUnixNano(res.Expire), d: time.Duration(res.Delta)}) {
+				continue
+			}
+			// 获取期间 key 被删除了，删除总是先调用 ForgetPrevious 再删除本机的缓存值，所以写入之后再检查一次
+			if p.forgotten(h, g.name, key) {
+				g.mainCache.remove(key)
+				continue
+			}
+			p.handoffKeys.Add(1)
+		}
+	}
+}
+
+// finishHandoff 迁移完成后不再向原来的 owner 回退
+func (p *HTTPPool) finishHandoff(h *handoff) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, x := range p.handoffs {
+		if x == h {
+			p.handoffs = append(p.handoffs[:i], p.handoffs[i+1:]...)
+			return
+		}
+	}
+}
+
+// PickPrevious 实现 HandoffPicker，key 落在正在迁移的区间内并且迁移期间没有被删除时返回原来的 owner
+func (p *HTTPPool) PickPrevious(group, key string) (PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	h := p.handoffFor(key)
+	if h == nil {
+		return nil, false
+	}
+	if _, removed := h.removed[groupKey{group, key}]; removed {
+		return nil, false
+	}
+	return h.client, true
+}
+
+// ForgetPrevious 实现 HandoffPicker，迁移完成或者放弃之后记录随之丢弃
+func (p *HTTPPool) ForgetPrevious(group, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h := p.handoffFor(key); h != nil {
+		h.removed[groupKey{group, key}] = struct{}{}
+	}
+}
+
+// forgotten key 是否在迁移期间被删除了
+func (p *HTTPPool) forgotten(h *handoff, group, key string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, removed := h.removed[groupKey{group, key}]
+	return removed
+}
+
+// handoffFor 返回 key 所在的正在进行的迁移，调用者需要持有 p.mu
+func (p *HTTPPool) handoffFor(key string) *handoff {
+	if len(p.handoffs) == 0 {
+		return nil
+	}
+	ring, ok := p.peers.(*consistentHash.Map)
+	if !ok {
+		return nil
+	}
+	hash := ring.Hash(key)
+	for _, h := range p.handoffs {
+		if h.contains(hash) {
+			return h
+		}
+	}
+	return nil
+}
+
+// serveHandoff 响应新的 owner 的查询，返回 mainCache 中落在请求的区间内的 key，
+// 已经过期、只是为了 stale 窗口保留的缓存值不迁移
+func (p *HTTPPool) serveHandoff(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.HandoffRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := GetGroup(in.Group)
+	if group == nil {
+		http.Error(w, "no such group: "+in.Group, http.StatusNotFound)
+		return
+	}
+	p.mu.RLock()
+	ring, ok := p.peers.(*consistentHash.Map)
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, "handoff requires the consistent hash ring", http.StatusBadRequest)
+		return
+	}
+	h := &handoff{}
+	for _, r := range in.Ranges {
+		h.ranges = append(h.ranges, consistentHash.Range{Start: r.Start, End: r.End})
+	}
+	out := &pb.HandoffResponse{}
+	now := time.Now()
+	for _, key := range group.mainCache.keys() {
+		if !h.contains(ring.Hash(key)) {
+			continue
+		}
+		// 迁移的查询不计入缓存的统计，也不能提升 key 在原来的 owner 上的淘汰顺序和访问频率
+		if view, ok := group.mainCache.peek(key); ok && !view.expired(now) {
+			out.Keys = append(out.Keys, key)
+		}
+	}
+	body, err = proto.Marshal(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// servePeek 只返回 mainCache 中已有的缓存值，没有或者已经过期时返回 404，不向其它节点或数据源加载，
+// 否则 stale 窗口内保留的旧值会被新的 owner 当作新值保存。新的 owner 的读取不计入本节点的命中率，也不影响淘汰顺序
+func (p *HTTPPool) servePeek(w http.ResponseWriter, group *Group, key string) {
+	view, ok := group.mainCache.peek(key)
+	if !ok || view.expired(time.Now()) {
+		http.Error(w, "not cached: "+key, http.StatusNotFound)
+		return
+	}
+	p.writeView(w, view)
+}
+
+// handoffKeys 向原来的 owner 查询 group 中落在 ranges 内的 key
+func (h *httpClient) handoffKeys(ctx context.Context, group string, ranges []consistentHash.Range) ([]string, error) {
+	in := &pb.HandoffRequest{Group: group}
+	for _, r := range ranges {
+		in.Ranges = append(in.Ranges, &pb.Range{Start: r.Start, End: r.End})
+	}
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request body: %v", err)
+	}
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseUrl+handoffPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	out := &pb.HandoffResponse{}
+	if err = proto.Unmarshal(data, out); err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	return out.Keys, nil
+}
+
+// 检查 HTTPPool 是否实现了 HandoffPicker
+var _ HandoffPicker = (*HTTPPool)(nil)
@@ -0,0 +1,300 @@
+package distributedCache
+
+import (
+	"bytes"
+	"context"
+	"distributedCache/consistentHash"
+	"distributedCache/pb"
+	"google.golang.org/protobuf/proto"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newOldOwner 启动一个作为原来的 owner 的远程节点，mainCache 中保存了 values，只响应迁移查询和 peek 请求
+func newOldOwner(values map[string]string, peeks *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == defaultBasePath+handoffPath {
+			body, _ := ioutil.ReadAll(r.Body)
+			in := &pb.HandoffRequest{}
+			proto.Unmarshal(body, in)
+			out := &pb.HandoffResponse{}
+			for key := range values {
+				for _, rg := range in.Ranges {
+					if (consistentHash.Range{Start: rg.Start, End: rg.End}).Contains(crc32.ChecksumIEEE([]byte(key))) {
+						out.Keys = append(out.Keys, key)
+						break
+					}
+				}
+			}
+			body, _ = proto.Marshal(out)
+			w.Write(body)
+			return
+		}
+		if r.Header.Get(peekHeader) == "" {
+			http.Error(w, "expected a peek request", http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(peeks, 1)
+		parts := strings.SplitN(r.URL.Path[len(defaultBasePath):], "/", 2)
+		value, ok := values[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte(value)})
+		w.Write(body)
+	}))
+}
+
+// ownedKeys 返回 key0 到 key(n-1) 中在哈希环上属于 addr 的 key
+func ownedKeys(ring PeerSelector, addr string, n int) []string {
+	var keys []string
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		if ring.Get(key) == addr {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestHandoff(t *testing.T) {
+	values := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		values["key"+strconv.Itoa(i)] = "old" + strconv.Itoa(i)
+	}
+	var peeks int32
+	old := newOldOwner(values, &peeks)
+	defer old.Close()
+
+	var loads int32
+	g := NewGroup("handoff", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("db"), nil
+	}))
+	pool := NewHTTPPool("http://self", WithHandoff(10000))
+	defer pool.Close()
+	g.RegisterPeers(pool)
+	pool.Set(old.URL)
+	// 新节点加入后，本节点从原来的 owner 迁移新分到的 key
+	pool.Set(old.URL, "http://self")
+	moved := ownedKeys(pool.peers, "http://self", 100)
+	if len(moved) == 0 {
+		t.Fatal("no keys moved to self")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Handoffs != int64(len(moved)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("handoffs = %d, expect %d", pool.Stats().Handoffs, len(moved))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, key := range moved {
+		if v, err := g.Get(key); err != nil || v.String() != values[key] {
+			t.Fatalf("Get(%s) = %v, %v, expect %s", key, v, err, values[key])
+		}
+	}
+	if atomic.LoadInt32(&loads) != 0 || g.Stats.HandoffLoads.Get() != 0 {
+		t.Fatalf("loads = %d, handoff loads = %d, expect all keys migrated", loads, g.Stats.HandoffLoads.Get())
+	}
+	// 迁移完成后不再回退到原来的 owner
+	if _, ok := pool.PickPrevious(g.name, moved[0]); ok {
+		t.Fatal("PickPrevious after handoff finished")
+	}
+}
+
+func TestHandoffFallback(t *testing.T) {
+	var peeks int32
+	values := make(map[string]string)
+	old := newOldOwner(values, &peeks)
+	defer old.Close()
+	// 迁移开始之前准备好原来的 owner 的缓存值
+	ring := RingSelector()
+	ring.Add(old.URL, "http://self")
+	moved := ownedKeys(ring, "http://self", 100)
+	if len(moved) < 2 {
+		t.Fatal("not enough keys moved to self")
+	}
+	values[moved[0]] = "old"
+
+	var loads int32
+	g := NewGroup("handoff-fallback", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("db"), nil
+	}))
+	// 每秒只迁移一个 key，测试期间迁移还没有完成
+	pool := NewHTTPPool("http://self", WithHandoff(1))
+	defer pool.Close()
+	g.RegisterPeers(pool)
+	pool.Set(old.URL)
+	pool.Set(old.URL, "http://self")
+
+	// 原来的 owner 有缓存值时直接使用，不访问数据源
+	if v, err := g.Get(moved[0]); err != nil || v.String() != "old" {
+		t.Fatalf("Get = %v, %v, expect old", v, err)
+	}
+	// 原来的 owner 没有缓存值时回退到数据源
+	if v, err := g.Get(moved[1]); err != nil || v.String() != "db" {
+		t.Fatalf("Get = %v, %v, expect db", v, err)
+	}
+	if atomic.LoadInt32(&loads) != 1 || atomic.LoadInt32(&peeks) != 2 || g.Stats.HandoffLoads.Get() != 1 {
+		t.Fatalf("loads = %d, peeks = %d, handoff loads = %d", loads, peeks, g.Stats.HandoffLoads.Get())
+	}
+	// 不属于本节点的 key 不回退
+	if _, ok := pool.PickPrevious(g.name, ownedKeys(pool.peers, old.URL, 100)[0]); ok {
+		t.Fatal("PickPrevious for a key owned by the old owner")
+	}
+}
+
+func TestHandoffRemove(t *testing.T) {
+	values := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		values["key"+strconv.Itoa(i)] = "old"
+	}
+	var peeks int32
+	old := newOldOwner(values, &peeks)
+	defer old.Close()
+	// 原来的 owner 在 gate 关闭之前不返回迁移的 key，保证删除发生在迁移期间
+	gate := make(chan struct{})
+	gated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == defaultBasePath+handoffPath {
+			<-gate
+		}
+		old.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer gated.Close()
+	ring := RingSelector()
+	ring.Add(gated.URL, "http://self")
+	moved := ownedKeys(ring, "http://self", 100)
+	if len(moved) < 3 {
+		t.Fatal("not enough keys moved to self")
+	}
+
+	g := NewGroup("handoff-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db"), nil
+	}))
+	pool := NewHTTPPool("http://self", WithHandoff(10000))
+	defer pool.Close()
+	g.RegisterPeers(pool)
+	pool.Set(gated.URL)
+	pool.Set(gated.URL, "http://self")
+
+	// 迁移期间删除的 key 不再从原来的 owner 获取，也不会被迁移过来
+	g.Remove(moved[0])
+	g.Remove(moved[1])
+	if v, err := g.Get(moved[0]); err != nil || v.String() != "db" {
+		t.Fatalf("Get removed key = %v, %v, expect db", v, err)
+	}
+	close(gate)
+	waitUntil(t, func() bool { return pool.Stats().Handoffs == int64(len(moved)-2) })
+	if v, err := g.Get(moved[1]); err != nil || v.String() != "db" {
+		t.Fatalf("Get removed key = %v, %v, expect db", v, err)
+	}
+	if v, err := g.Get(moved[2]); err != nil || v.String() != "old" {
+		t.Fatalf("Get migrated key = %v, %v, expect old", v, err)
+	}
+}
+
+func TestServeHandoff(t *testing.T) {
+	var loads int32
+	g := NewGroup("serve-handoff", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("db"), nil
+	}))
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a")
+	for i := 0; i < 100; i++ {
+		g.populateCache("key"+strconv.Itoa(i), ByteView{b: []byte("v")})
+	}
+	before := pool.peers.(*consistentHash.Map)
+	pool.Set("http://a", "http://b")
+	after := pool.peers.(*consistentHash.Map)
+
+	in := &pb.HandoffRequest{Group: g.name}
+	for _, r := range consistentHash.Diff(before, after) {
+		in.Ranges = append(in.Ranges, &pb.Range{Start: r.Start, End: r.End})
+	}
+	body, _ := proto.Marshal(in)
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodPost, defaultBasePath+handoffPath, bytes.NewReader(body)))
+	out := &pb.HandoffResponse{}
+	if err := proto.Unmarshal(w.Body.Bytes(), out); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("code = %d, err = %v", w.Code, err)
+	}
+	if expect := ownedKeys(pool.peers, "http://b", 100); len(out.Keys) != len(expect) || len(expect) == 0 {
+		t.Fatalf("got %d keys, expect %d", len(out.Keys), len(expect))
+	}
+	for _, key := range out.Keys {
+		if after.Get(key) != "http://b" {
+			t.Fatalf("key %s does not belong to b", key)
+		}
+	}
+
+	// peek 请求只返回已有的缓存值，不会加载
+	peek := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, defaultBasePath+g.name+"/"+key, nil)
+		req.Header.Set(peekHeader, "1")
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := peek("key0"); code != http.StatusOK {
+		t.Fatalf("peek cached key: %d", code)
+	}
+	if code := peek("missing"); code != http.StatusNotFound || loads != 0 {
+		t.Fatalf("peek missing key: %d, loads = %d", code, loads)
+	}
+	// 迁移查询和 peek 请求都不计入本节点的命中率
+	if stats := g.CacheStats(MainCache); stats.Gets != 0 || stats.Hits != 0 {
+		t.Fatalf("handoff reads counted as client gets: %+v", stats)
+	}
+}
+
+func TestHandoffRateLimit(t *testing.T) {
+	// 间隔小于 1ns 时 time.NewTicker 会 panic，rate 最多为每秒 1e9
+	pool := NewHTTPPool("http://self", WithHandoff(math.MaxInt))
+	if pool.handoffRate != int(time.Second) {
+		t.Fatalf("handoff rate = %d", pool.handoffRate)
+	}
+	pool.runHandoffs(context.Background(), nil)
+}
+
+func TestServeHandoffSkipsExpired(t *testing.T) {
+	g := NewGroup("serve-handoff-expired", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db"), nil
+	}), WithStaleWhileRevalidate(time.Hour))
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b")
+	// 已经过期的缓存值在 stale 窗口内仍然保留在 mainCache 中
+	for i := 0; i < 10; i++ {
+		g.populateCache("key"+strconv.Itoa(i), ByteView{b: []byte("v"), e: time.Now().Add(-time.Minute)})
+	}
+	g.populateCache("fresh", ByteView{b: []byte("v"), e: time.Now().Add(time.Minute)})
+
+	// 整个环都请求迁移，只有没有过期的 key 被返回
+	in := &pb.HandoffRequest{Group: g.name, Ranges: []*pb.Range{{Start: 0, End: 0}}}
+	body, _ := proto.Marshal(in)
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodPost, defaultBasePath+handoffPath, bytes.NewReader(body)))
+	out := &pb.HandoffResponse{}
+	if err := proto.Unmarshal(w.Body.Bytes(), out); err != nil || len(out.Keys) != 1 || out.Keys[0] != "fresh" {
+		t.Fatalf("keys = %v, err = %v, expect only the fresh key", out.Keys, err)
+	}
+
+	// peek 不返回过期的旧值
+	req := httptest.NewRequest(http.MethodGet, defaultBasePath+g.name+"/key0", nil)
+	req.Header.Set(peekHeader, "1")
+	w = httptest.NewRecorder()
+	pool.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("peek expired key: %d", w.Code)
+	}
+}
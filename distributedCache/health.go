@@ -0,0 +1,229 @@
+package distributedCache
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 跟踪远程节点的健康状态：主动定期请求远程节点的 /healthz，被动统计正常请求失败的次数，
+// 连续失败达到阈值的节点被暂时摘除，PickPeer 跳过它选择哈希环上的下一个节点，不用每次都等待请求失败
+
+// healthPath 健康检查的路径，完整的地址是 basePath + healthPath，例如 /_cache/healthz
+const healthPath = "healthz"
+
+const (
+	defaultFailureThreshold = 3
+	defaultEjectionTime     = 10 * time.Second
+)
+
+// peerHealth 一个远程节点的健康状态，nil 表示不跟踪，总是可用
+type peerHealth struct {
+	addr         string
+	threshold    int           // 连续失败多少次被摘除
+	ejectionTime time.Duration // 摘除多久之后允许再次尝试
+	total        *AtomicInt    // HTTPPool 中所有节点被摘除的总次数
+
+	mu        sync.Mutex
+	failures  int       // 连续失败的次数，成功一次就清零
+	ejected   bool      // 是否被摘除
+	ejectedAt time.Time // 最近一次被摘除的时间
+	ejections int64     // 被摘除的次数
+	lastErr   string    // 最近一次失败的原因
+}
+
+// available 节点是否可以被选择，被摘除的节点超过 ejectionTime 之后允许再次尝试，尝试成功就恢复，失败就重新摘除
+func (h *peerHealth) available() bool {
+	if h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.ejected || time.Since(h.ejectedAt) >= h.ejectionTime
+}
+
+// success 记录一次成功，被摘除的节点恢复
+func (h *peerHealth) success() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = 0
+	if h.ejected {
+		h.ejected = false
+		log.Printf("[Health] peer %s recovered", h.addr)
+	}
+}
+
+// failure 记录一次失败，连续失败达到阈值时摘除节点，已经摘除的节点重新计时
+func (h *peerHealth) failure(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.lastErr = err.Error()
+	if h.failures < h.threshold {
+		return
+	}
+	if !h.ejected {
+		h.ejected = true
+		h.ejections++
+		if h.total != nil {
+			h.total.Add(1)
+		}
+		log.Printf("[Health] peer %s ejected after %d failures: %v", h.addr, h.failures, err)
+	}
+	h.ejectedAt = time.Now()
+}
+
+// PeerHealth 某一个远程节点在某一时刻的健康状态快照
+type PeerHealth struct {
+	Addr      string // 节点地址
+	Healthy   bool   // 是否可以被 PickPeer 选择
+	Failures  int    // 连续失败的次数
+	Ejections int64  // 被摘除的次数
+	LastError string // 最近一次失败的原因
+	Circuit   string // 断路器的状态，没有使用断路器时为空
+	InFlight  int64  // 正在进行的 Get 请求数
+}
+
+// PoolStats HTTPPool 的统计信息快照
+type PoolStats struct {
+	Peers     []PeerHealth // 除自己以外的所有节点，按地址排序
+	Ejections int64        // 所有节点被摘除的总次数，包括已经删除的节点
+	Hedges    int64        // 发送的对冲请求数
+	Retries   int64        // 遇到瞬时错误后的重试次数
+	Throttled int64        // 因为重试预算不足没有发送的对冲和重试请求数
+	Spills    int64        // 开启有界负载时，因为 key 所属的节点已满而溢出到下一个节点的次数
+	Handoffs  int64        // 开启迁移时，节点变化后从原来的 owner 迁移过来的缓存值个数
+}
+
+// Stats 返回所有远程节点的健康状态
+func (p *HTTPPool) Stats() PoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := PoolStats{
+		Ejections: p.ejections.Get(),
+		Hedges:    p.hedges.Get(),
+		Retries:   p.retries.Get(),
+		Throttled: p.throttled.Get(),
+		Spills:    p.spills.Get(),
+		Handoffs:  p.handoffKeys.Get(),
+	}
+	for addr, client := range p.httpClients {
+		if addr == p.self || client.health == nil {
+			continue
+		}
+		h := client.health
+		available := h.available()
+		h.mu.Lock()
+		peer := PeerHealth{
+			Addr:      addr,
+			Healthy:   available,
+			Failures:  h.failures,
+			Ejections: h.ejections,
+			LastError: h.lastErr,
+			InFlight:  client.inFlight.Get(),
+		}
+		h.mu.Unlock()
+		if client.breaker != nil {
+			peer.Circuit = client.breaker.State().String()
+		}
+		stats.Peers = append(stats.Peers, peer)
+	}
+	sort.Slice(stats.Peers, func(i, j int) bool { return stats.Peers[i].Addr < stats.Peers[j].Addr })
+	return stats
+}
+
+// serveHealth 响应健康检查
+func (p *HTTPPool) serveHealth(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+// healthLoop 每隔 healthInterval 并发地检查所有远程节点，直到 Close 被调用
+func (p *HTTPPool) healthLoop() {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkPeers()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// checkPeers 检查一次所有远程节点，每个节点的检查不超过 healthInterval
+func (p *HTTPPool) checkPeers() {
+	p.mu.RLock()
+	clients := make([]*httpClient, 0, len(p.httpClients))
+	for addr, client := range p.httpClients {
+		if addr != p.self {
+			clients = append(clients, client)
+		}
+	}
+	p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.healthInterval)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *httpClient) {
+			defer wg.Done()
+			client.checkHealth(ctx)
+		}(client)
+	}
+	wg.Wait()
+}
+
+// checkHealth 请求远程节点的 /healthz，根据结果更新健康状态
+func (h *httpClient) checkHealth(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.baseUrl+healthPath, nil)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.health.failure(err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		h.health.failure(&statusError{code: res.StatusCode, status: res.Status})
+		return
+	}
+	h.health.success()
+}
+
+// statusError 远程节点返回了表示失败的状态码
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "server returned: " + e.status
+}
+
+// Close 停止后台的健康检查和正在进行的迁移
+func (p *HTTPPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.handoffCancel != nil {
+		p.handoffCancel()
+		p.handoffCancel = nil
+	}
+	p.handoffs = nil
+}
@@ -0,0 +1,250 @@
+package distributedCache
+
+import (
+	"context"
+	"distributedCache/pb"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 对冲请求和重试：key 所属的节点超过一定时间没有应答时，向哈希环上的下一个节点（或者本节点）再发一个请求，
+// 哪个先返回就用哪个；遇到瞬时错误时换到下一个节点重试。额外的请求都要从重试预算中扣除，避免故障时请求量成倍增加。
+// 下一个节点收到的请求带有 forwardedHeader，由它自己加载，不会再转回 key 所属的节点
+
+const (
+	latencyWindow     = 128                   // 计算对冲延迟时保留的最近请求耗时的个数
+	minLatencySamples = 16                    // 样本不足时使用 defaultHedgeDelay
+	defaultHedgeDelay = 50 * time.Millisecond // 样本不足时的对冲延迟
+	defaultRetryRatio = 0.1                   // 默认每 10 个请求允许 1 个额外请求
+	retryBudgetBurst  = 10                    // 重试预算最多积累的额外请求数
+)
+
+// latencyTracker 保存最近 latencyWindow 个请求的耗时，用来计算百分位数
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	n       int // 已经记录的个数，超过 latencyWindow 后循环覆盖
+}
+
+// observe 记录一次请求的耗时
+func (l *latencyTracker) observe(d time.Duration) {
+	l.mu.Lock()
+	l.samples[l.n%latencyWindow] = d
+	l.n++
+	l.mu.Unlock()
+}
+
+// percentile 返回最近请求耗时的 q 分位数，样本不足时返回 false
+func (l *latencyTracker) percentile(q float64) (time.Duration, bool) {
+	l.mu.Lock()
+	n := l.n
+	if n > latencyWindow {
+		n = latencyWindow
+	}
+	if n < minLatencySamples {
+		l.mu.Unlock()
+		return 0, false
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, l.samples[:n])
+	l.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(q * float64(n))
+	if i >= n {
+		i = n - 1
+	}
+	return samples[i], true
+}
+
+// retryBudget 重试预算，每个正常的请求存入 ratio 个令牌，每个重试或对冲请求取出一个令牌，
+// 令牌不足时不再发送额外的请求，故障期间额外的请求最多占正常请求的 ratio
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: retryBudgetBurst}
+}
+
+// deposit 记录一个正常的请求
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetBurst {
+		b.tokens = retryBudgetBurst
+	}
+	b.mu.Unlock()
+}
+
+// withdraw 申请发送一个额外的请求，预算不足时返回 false
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// hedgeDelay 对冲延迟，使用最近请求耗时的 hedgePercentile 分位数
+func (p *HTTPPool) hedgeDelay() time.Duration {
+	if d, ok := p.latency.percentile(p.hedgePercentile); ok {
+		return d
+	}
+	return defaultHedgeDelay
+}
+
+// hedgedGetter PickPeer 在开启对冲或重试时返回的 PeerGetter，primary 是读请求首选的节点，通常就是 key 所属的节点 owner，
+// backup 是哈希环上的下一个可用节点，下一个节点是自己时为 localPeer，没有下一个节点时为 nil
+type hedgedGetter struct {
+	pool    *HTTPPool
+	owner   *httpClient
+	primary PeerGetter
+	backup  PeerGetter
+}
+
+// peerResult 一个请求的结果
+type peerResult struct {
+	out *pb.Response
+	err error
+}
+
+// Get 先请求 primary，超过对冲延迟没有应答或者返回瞬时错误时请求 backup，返回最先成功的结果
+func (h *hedgedGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p := h.pool
+	p.retryBudget.deposit()
+	// 返回之后取消还没有结束的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan peerResult, 2)
+	send := func(peer PeerGetter, primary bool) {
+		start := time.Now()
+		res := &pb.Response{}
+		err := peer.Get(ctx, in, res)
+		if primary && err == nil {
+			p.latency.observe(time.Since(start))
+		}
+		results <- peerResult{out: res, err: err}
+	}
+	go send(h.primary, true)
+
+	var hedge <-chan time.Time
+	if p.hedgePercentile > 0 && h.backup != nil {
+		timer := time.NewTimer(p.hedgeDelay())
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	pending, sentBackup := 1, false
+	var firstErr error
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Merge(out, r.out)
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !sentBackup && p.retryRatio > 0 && h.backup != nil && ctx.Err() == nil && transient(r.err) {
+				if p.retryBudget.withdraw() {
+					p.retries.Add(1)
+					sentBackup, hedge = true, nil
+					pending++
+					go send(h.backup, false)
+					continue
+				}
+				p.throttled.Add(1)
+			}
+			if pending == 0 {
+				return firstErr
+			}
+		case <-hedge:
+			hedge = nil
+			if !sentBackup {
+				if p.retryBudget.withdraw() {
+					p.hedges.Add(1)
+					sentBackup = true
+					pending++
+					go send(h.backup, false)
+				} else {
+					p.throttled.Add(1)
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Set 写请求不对冲，只发给 owner
+func (h *hedgedGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	return h.owner.Set(ctx, in)
+}
+
+// Remove 写请求不对冲，只发给 owner
+func (h *hedgedGetter) Remove(ctx context.Context, in *pb.Request) error {
+	return h.owner.Remove(ctx, in)
+}
+
+// transient 错误是否是瞬时的：连接失败、超时、过载、断路器打开和 502/503/504 换一个节点可能成功，
+// key 不存在、请求不合法和数据源返回的错误不是
+func transient(err error) bool {
+	if !retryLocally(err) {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= http.StatusBadGateway
+	}
+	return true
+}
+
+// localPeer 把本节点当作 PeerGetter，哈希环上的下一个节点是自己时作为 backup，直接从数据源加载
+type localPeer struct{}
+
+// Get 从本节点的数据源加载
+func (localPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	group := GetGroup(in.Group)
+	if group == nil {
+		return errors.New("no such group: " + in.Group)
+	}
+	view, err := group.getLocally(ctx, in.Key)
+	if err != nil {
+		return err
+	}
+	out.Value = view.ByteSlice()
+	out.Expire = expireToUnixNano(view.Expire())
+	return nil
+}
+
+// Set 写入本节点的缓存
+func (localPeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	group := GetGroup(in.Group)
+	if group == nil {
+		return errors.New("no such group: " + in.Group)
+	}
+	group.populateCache(in.Key, ByteView{b: in.Value, e: expireFromUnixNano(in.Expire)})
+	return nil
+}
+
+// Remove 删除本节点的缓存
+func (localPeer) Remove(ctx context.Context, in *pb.Request) error {
+	group := GetGroup(in.Group)
+	if group == nil {
+		return errors.New("no such group: " + in.Group)
+	}
+	group.removeLocally(in.Key)
+	return nil
+}
+
+var _ PeerGetter = (*hedgedGetter)(nil)
+var _ PeerGetter = localPeer{}
@@ -0,0 +1,171 @@
+package distributedCache
+
+import (
+	"context"
+	"distributedCache/pb"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newValueServer 启动一个总是返回 value 的远程节点，delay 模拟慢节点，status 不为 0 时返回这个状态码
+func newValueServer(value string, delay time.Duration, status int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte(value)})
+		w.Write(body)
+	}))
+}
+
+// keyOwnedBy 找到一个在哈希环上依次属于 first 和 second 的 key
+func keyOwnedBy(pool *HTTPPool, first, second string) string {
+	for i := 0; ; i++ {
+		key := strconv.Itoa(i)
+		var order []string
+		pool.peers.Walk(key, func(addr string) bool {
+			order = append(order, addr)
+			return len(order) < 2
+		})
+		if order[0] == first && order[1] == second {
+			return key
+		}
+	}
+}
+
+// newPoolServer 启动一个节点，HTTPPool 使用服务器自己的地址
+func newPoolServer(opts ...PoolOption) (*httptest.Server, *HTTPPool) {
+	var pool *HTTPPool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	pool = NewHTTPPool(server.URL, opts...)
+	return server, pool
+}
+
+func TestHedging(t *testing.T) {
+	var slowRequests, fastRequests int32
+	slow := newValueServer("slow", time.Second, 0, &slowRequests)
+	defer slow.Close()
+	fast := newValueServer("fast", 0, 0, &fastRequests)
+	defer fast.Close()
+
+	pool := NewHTTPPool("http://self", WithHedging(0.95))
+	pool.Set("http://self", slow.URL, fast.URL)
+	key := keyOwnedBy(pool, slow.URL, fast.URL)
+	peer, ok := pool.PickPeer(key)
+	if !ok {
+		t.Fatal("expected a peer")
+	}
+
+	// key 所属的节点很慢，超过对冲延迟后向下一个节点发送请求，使用先返回的结果
+	start := time.Now()
+	out := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: key}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Value) != "fast" || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("hedged request got %q after %v", out.Value, time.Since(start))
+	}
+	if stats := pool.Stats(); stats.Hedges != 1 || stats.Retries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHedgingBackupLoadsLocally(t *testing.T) {
+	var ownerRequests int32
+	owner := newValueServer("owner", time.Second, 0, &ownerRequests)
+	defer owner.Close()
+	backup, backupPool := newPoolServer()
+	defer backup.Close()
+	g := NewGroup("hedgeBackup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("backup"), nil
+	}))
+	g.RegisterPeers(backupPool)
+	backupPool.Set("http://self", owner.URL, backup.URL)
+
+	pool := NewHTTPPool("http://self", WithHedging(0.95))
+	pool.Set("http://self", owner.URL, backup.URL)
+	key := keyOwnedBy(pool, owner.URL, backup.URL)
+	peer, _ := pool.PickPeer(key)
+
+	// 备用节点直接从自己的数据源加载，不会把对冲请求转回很慢的 owner
+	start := time.Now()
+	out := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: g.name, Key: key}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Value) != "backup" || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("hedged request got %q after %v", out.Value, time.Since(start))
+	}
+	if n := atomic.LoadInt32(&ownerRequests); n != 1 || g.Stats.LocalLoads.Get() != 1 {
+		t.Fatalf("owner got %d requests, backup loaded %v times", n, &g.Stats.LocalLoads)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var downRequests, upRequests int32
+	down := newValueServer("", 0, http.StatusServiceUnavailable, &downRequests)
+	defer down.Close()
+	up := newValueServer("up", 0, 0, &upRequests)
+	defer up.Close()
+
+	pool := NewHTTPPool("http://self", WithRetryBudget(0.1), WithFailureThreshold(1000))
+	pool.Set("http://self", down.URL, up.URL)
+	key := keyOwnedBy(pool, down.URL, up.URL)
+
+	// 瞬时错误换到下一个节点重试，预算用完之后不再重试，直接返回错误
+	const requests = 50
+	var failed int
+	for i := 0; i < requests; i++ {
+		peer, _ := pool.PickPeer(key)
+		if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: key}, &pb.Response{}); err != nil {
+			failed++
+		}
+	}
+	stats := pool.Stats()
+	if stats.Retries < retryBudgetBurst || stats.Retries > retryBudgetBurst+requests/10 {
+		t.Fatalf("retries %d not capped by the budget", stats.Retries)
+	}
+	if stats.Throttled == 0 || int64(failed) != stats.Throttled {
+		t.Fatalf("failed %d, throttled %d", failed, stats.Throttled)
+	}
+	if int64(atomic.LoadInt32(&upRequests)) != stats.Retries {
+		t.Fatalf("backup got %d requests, want %d", upRequests, stats.Retries)
+	}
+}
+
+func TestHedgingLocal(t *testing.T) {
+	g := NewGroup("hedgeLocal", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	var slowRequests int32
+	slow := newValueServer("slow", time.Second, 0, &slowRequests)
+	defer slow.Close()
+
+	pool := NewHTTPPool("http://self", WithHedging(0.95))
+	pool.Set("http://self", slow.URL)
+	key := keyOwnedBy(pool, slow.URL, "http://self")
+	peer, _ := pool.PickPeer(key)
+
+	// 哈希环上的下一个节点是自己，对冲请求直接从本地数据源加载
+	out := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: g.name, Key: key}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Value) != "local" {
+		t.Fatalf("expected local value, got %q", out.Value)
+	}
+}
@@ -1,30 +1,76 @@
 package distributedCache
 
 import (
+	"bytes"
+	"context"
+	"distributedCache/circuitBreaker"
 	"distributedCache/consistentHash"
+	"distributedCache/membership"
 	"distributedCache/pb"
+	"errors"
 	"fmt"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const defaultBasePath = "/_cache/"
 const defaultReplicas = 50
 
+// defaultTimeout 请求远程节点的默认超时时间，调用者的 ctx 没有设置截止时间时使用
+const defaultTimeout = 10 * time.Second
+
+// timeoutHeader 请求头中携带调用者剩余的超时时间（毫秒），远程节点据此设置自己的截止时间
+const timeoutHeader = "X-Cache-Timeout"
+
+// forwardedHeader 节点之间的 GET 请求都带有 forwardedHeader，收到请求的节点未命中时直接加载，不再转发给其它节点，
+// 否则对冲、重试和有界负载选中的备用节点会把请求转回 key 所属的节点
+const forwardedHeader = "X-Cache-Forwarded"
+
 // 实现服务端
 
 // HTTPPool 承载节点间 HTTP 通信的核心数据结构
 type HTTPPool struct {
 	self        string                 // 保存自己的地址
 	basePath    string                 // 通讯地址的前缀，默认是 /_cache/
-	mu          sync.Mutex             // 保证节点选择时的并发安全
-	peers       *consistentHash.Map    // 类型是一致性哈希算法的 Map，用来根据具体的 key 选择节点
+	mu          sync.RWMutex           // 保证节点选择和节点增删时的并发安全
+	peers       PeerSelector           // 节点选择策略，默认是一致性哈希算法的 Map，用来根据具体的 key 选择节点
+	newSelector SelectorFactory        // 创建节点选择策略
 	httpClients map[string]*httpClient // 映射远程节点地址和对应的 httpClient, 每一个远程节点对应一个 httpClient，因为 httpClient 与远程节点的地址 baseURL 有关
+	timeout     time.Duration          // 请求远程节点的默认超时时间
+	replicas    int                    // 每个 key 的副本数
+	weights     map[string]int         // 节点的权重，没有设置的节点权重为 1
+	loadEpsilon float64                // 有界负载的 ε，每个节点的容量是平均负载的 1+ε 倍，为 0 表示不限制
+	spills      AtomicInt              // 因为 key 所属的节点已满而溢出到下一个节点的次数
+
+	healthInterval   time.Duration            // 主动健康检查的时间间隔，为 0 表示只根据请求结果被动检查
+	failureThreshold int                      // 连续失败多少次摘除节点
+	ejectionTime     time.Duration            // 节点被摘除多久之后允许再次尝试
+	ejections        AtomicInt                // 节点被摘除的总次数
+	breakerSettings  *circuitBreaker.Settings // 每个远程节点的断路器配置，为 nil 表示不使用断路器
+	done             chan struct{}            // 关闭时停止健康检查
+
+	hedgePercentile float64        // 对冲延迟使用的请求耗时分位数，为 0 表示不对冲
+	retryRatio      float64        // 重试预算占正常请求的比例，为 0 表示不重试
+	retryBudget     *retryBudget   // 对冲和重试共用的预算
+	latency         latencyTracker // 最近请求 key 所属节点的耗时
+	hedges          AtomicInt      // 发送的对冲请求数
+	retries         AtomicInt      // 重试的次数
+	throttled       AtomicInt      // 因为预算不足放弃的对冲和重试次数
+
+	handoffRate   int                // 节点变化后每秒最多迁移的 key 数，为 0 表示不迁移
+	handoffs      []*handoff         // 正在进行的迁移，完成之前未命中的 key 先向原来的 owner 获取
+	handoffCancel context.CancelFunc // 放弃正在进行的迁移
+	handoffKeys   AtomicInt          // 迁移过来的缓存值个数
+	closeOnce     sync.Once
 }
 
 // Log 日志显示服务名
@@ -32,27 +78,58 @@ func (p *HTTPPool) Log(format string, v ...interface{}) {
 	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
 }
 
-// NewHTTPPool 初始化一个 HTTPPool
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+// NewHTTPPool 初始化一个 HTTPPool，opts 为可选配置，例如 WithTimeout 设置请求远程节点的超时时间
+// 使用 WithHealthCheck 开启主动健康检查或者 WithHandoff 开启迁移时，不再使用 HTTPPool 需要调用 Close
+func NewHTTPPool(self string, opts ...PoolOption) *HTTPPool {
+	p := &HTTPPool{
+		self:             self,
+		basePath:         defaultBasePath,
+		timeout:          defaultTimeout,
+		replicas:         1,
+		newSelector:      RingSelector,
+		failureThreshold: defaultFailureThreshold,
+		ejectionTime:     defaultEjectionTime,
+		done:             make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.healthInterval > 0 {
+		go p.healthLoop()
 	}
+	if p.hedgePercentile > 0 || p.retryRatio > 0 {
+		ratio := p.retryRatio
+		if ratio <= 0 {
+			ratio = defaultRetryRatio
+		}
+		p.retryBudget = newRetryBudget(ratio)
+	}
+	return p
 }
 
-// ServeHTTP 实现 http 方法
+// ServeHTTP 实现 http 方法，根据请求方法分发：GET 获取缓存值，PUT 写入缓存值，DELETE 删除缓存值
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// 如果请求不是以 默认前缀开始的则报错
 	if !strings.HasPrefix(req.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + req.URL.Path)
 	}
+	// 健康检查很频繁，不打印日志
+	if req.URL.Path == p.basePath+healthPath {
+		p.serveHealth(w)
+		return
+	}
 	// 显示请求方法和路径
 	p.Log("%s %s", req.Method, req.URL.Path)
+	if req.URL.Path == p.basePath+handoffPath {
+		p.serveHandoff(w, req)
+		return
+	}
 	// SplitN: s为待分割字符串，sep为分隔符，n为返回的字符串数
 	// /<basepath>/<groupname>/<key> 得到的是 groupname 和 key，也就是parts
 	parts := strings.SplitN(req.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
 	groupName := parts[0]
 	key := parts[1]
@@ -62,90 +139,442 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
-	// 获取缓存数据
-	view, err := group.Get(key)
+	group.Stats.ServerRequests.Add(1)
+	// 调用者携带了剩余的超时时间，本节点的处理也不应该超过这个时间
+	ctx := req.Context()
+	if ms, err := strconv.ParseInt(req.Header.Get(timeoutHeader), 10, 64); err == nil && ms > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+	switch req.Method {
+	case http.MethodGet:
+		if req.Header.Get(peekHeader) != "" {
+			p.servePeek(w, group, key)
+			return
+		}
+		p.serveGet(ctx, w, group, key, req.Header.Get(forwardedHeader) != "")
+	case http.MethodPut:
+		p.servePut(w, req, group, key)
+	case http.MethodDelete:
+		p.serveDelete(w, group, key)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveGet 获取缓存数据，把结果以 proto 的格式写入到响应体中，来自其它节点的请求在本节点加载，不再转发
+func (p *HTTPPool) serveGet(ctx context.Context, w http.ResponseWriter, group *Group, key string, forwarded bool) {
+	get := group.GetContext
+	if forwarded {
+		get = group.getForPeer
+	}
+	view, err := get(ctx, key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.writeError(w, err)
 		return
 	}
-	// 把结果以 proto 的格式写入到响应体中
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	p.writeView(w, view)
+}
+
+// writeView 把缓存值以 proto 的格式写入到响应体中
+func (p *HTTPPool) writeView(w http.ResponseWriter, view ByteView) {
+	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice(), Expire: expireToUnixNano(view.Expire()), Stale: view.Stale(), Delta: int64(view.d)})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
 	// 将缓存值作为 httpResponse 的 body 返回
 	w.Write(body)
 }
 
+// writeError 把错误转换为 pb.Code，以 proto 的格式写入到响应体中，状态码和错误的类别对应
+func (p *HTTPPool) writeError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	body, merr := proto.Marshal(&pb.Response{Code: code, Message: err.Error()})
+	if merr != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(httpStatus(code))
+	w.Write(body)
+}
+
+// servePut 处理 Group.Set 发来的写入请求，本节点是 key 所属的节点，直接写入本机缓存
+func (p *HTTPPool) servePut(w http.ResponseWriter, req *http.Request, group *Group, key string) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.SetRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.populateCache(key, ByteView{b: in.Value, e: expireFromUnixNano(in.Expire)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDelete 处理 Group.Remove 和 Group.Invalidate 发来的删除请求，只删除本机的缓存
+func (p *HTTPPool) serveDelete(w http.ResponseWriter, group *Group, key string) {
+	group.removeLocally(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Set 实例化了一致性哈希算法，并且添加了传入的节点， 并为每一个节点创建了一个 HTTP 客户端 httpGetter
+// Set 会替换掉已有的全部节点，新的哈希环构建完成后才替换旧的，替换过程中 PickPeer 不会看到空的哈希环
+// 开启 WithHandoff 时，本节点在后台从原来的 owner 迁移新分到的 key
 func (p *HTTPPool) Set(addrs ...string) {
+	// 实例化节点选择策略，默认是一致性哈希算法并采用默认的哈希函数
+	peers := p.newSelector()
+	// 添加节点，也就是真实的计算机节点
+	p.addToRing(peers, addrs)
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// 实例化一个一致性哈希算法并采用默认的哈希函数
-	p.peers = consistentHash.New(defaultReplicas, nil)
-	// 添加节点，也就是真实的计算机节点
-	p.peers.Add(addrs...)
-	// 为每一个节点创建一个客户端并保存在 map 中
-	p.httpClients = make(map[string]*httpClient, len(addrs))
+	// 为每一个节点创建一个客户端并保存在 map 中，已经存在的节点保留原来的客户端和健康状态
+	httpClients := make(map[string]*httpClient, len(addrs))
 	for _, addr := range addrs {
-		// http://localhost:8001/_cache/
-		p.httpClients[addr] = &httpClient{baseUrl: addr + p.basePath}
+		if client, ok := p.httpClients[addr]; ok {
+			httpClients[addr] = client
+		} else {
+			httpClients[addr] = p.newClient(addr)
+		}
 	}
+	p.httpClients = httpClients
+	p.setRing(peers)
 }
 
-// PickPeer 包装了一致性哈希算法的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 HTTP 客户端
-func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
+// AddPeers 增量添加节点，已经存在的节点会被忽略，只会在哈希环上增加新节点的虚拟节点
+func (p *HTTPPool) AddPeers(addrs ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// 根据 key 获取应该访问的节点地址
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		// peer 是根据 key 查找到的计算机节点 URL
-		p.Log("Pick peer from %s, key = %s", peer, key)
-		// 返回对应于这个请求地址的客户端实例
-		return p.httpClients[peer], true
+	if p.peers == nil {
+		p.peers = p.newSelector()
+		p.httpClients = make(map[string]*httpClient, len(addrs))
+	}
+	var added []string
+	for _, addr := range addrs {
+		if _, ok := p.httpClients[addr]; ok {
+			continue
+		}
+		p.httpClients[addr] = p.newClient(addr)
+		added = append(added, addr)
+	}
+	if len(added) == 0 {
+		return
+	}
+	if p.rebuildOnChange() {
+		p.setRing(p.rebuildRing())
+		return
+	}
+	p.addToRing(p.peers, added)
+}
+
+// rebuildOnChange 节点变化时是否重建节点选择策略而不是原地修改：迁移需要比较新旧两个哈希环；
+// jump 的编号取决于添加的顺序，各节点通过 gossip 收到成员变化的顺序不同，增量修改会让各节点对 key 的归属产生分歧
+func (p *HTTPPool) rebuildOnChange() bool {
+	_, jump := p.peers.(*consistentHash.Jump)
+	return p.handoffRate > 0 || jump
+}
+
+// addToRing 把节点按地址排序后加入节点选择策略，依赖添加顺序的策略（例如 jump）在所有节点上因此得到相同的结果，
+// WithPeerWeights 设置了权重的节点按权重添加，策略不支持权重时忽略权重
+func (p *HTTPPool) addToRing(ring PeerSelector, addrs []string) {
+	addrs = append([]string(nil), addrs...)
+	sort.Strings(addrs)
+	weighted, _ := ring.(weightedSelector)
+	var unweighted []string
+	for _, addr := range addrs {
+		if weight, ok := p.weights[addr]; ok && weighted != nil {
+			weighted.AddWeighted(addr, weight)
+		} else {
+			unweighted = append(unweighted, addr)
+		}
+	}
+	if len(unweighted) > 0 {
+		ring.Add(unweighted...)
 	}
-	return nil, false
+}
+
+// RemovePeers 增量删除节点，只会删除这些节点在哈希环上的虚拟节点
+func (p *HTTPPool) RemovePeers(addrs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return
+	}
+	var removed []string
+	for _, addr := range addrs {
+		if _, ok := p.httpClients[addr]; !ok {
+			continue
+		}
+		delete(p.httpClients, addr)
+		removed = append(removed, addr)
+	}
+	if len(removed) == 0 {
+		return
+	}
+	if p.rebuildOnChange() {
+		p.setRing(p.rebuildRing())
+		return
+	}
+	p.peers.Remove(removed...)
+}
+
+// newClient 为远程节点创建一个 HTTP 客户端
+func (p *HTTPPool) newClient(addr string) *httpClient {
+	// http://localhost:8001/_cache/
+	return &httpClient{
+		baseUrl: addr + p.basePath,
+		timeout: p.timeout,
+		health: &peerHealth{
+			addr:         addr,
+			threshold:    p.failureThreshold,
+			ejectionTime: p.ejectionTime,
+			total:        &p.ejections,
+		},
+		breaker: p.newBreaker(addr),
+	}
+}
+
+// newBreaker 为远程节点创建断路器，没有使用 WithCircuitBreaker 时返回 nil
+func (p *HTTPPool) newBreaker(addr string) *circuitBreaker.Breaker {
+	if p.breakerSettings == nil {
+		return nil
+	}
+	settings := *p.breakerSettings
+	settings.Name = addr
+	return circuitBreaker.New(settings)
+}
+
+// PickPeer 根据具体的 key 通过节点选择策略（默认是一致性哈希环）选择节点，返回节点对应的 HTTP 客户端
+// key 所属的节点被摘除时顺时针选择下一个节点，轮到自己时返回 false，由本节点加载
+// 开启有界负载时，读请求跳过已满的节点，写请求仍然发给 key 所属的节点；
+// 开启对冲或重试时返回 hedgedGetter，同时记下哈希环上的下一个可用节点作为备份
+func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	// 按哈希环上的顺序记录没有被摘除的节点，遇到自己为止，peer 是计算机节点 URL
+	var candidates []string
+	p.peers.Walk(key, func(peer string) bool {
+		if peer != p.self && !p.httpClients[peer].health.available() {
+			return true
+		}
+		candidates = append(candidates, peer)
+		return peer != p.self
+	})
+	if len(candidates) == 0 || candidates[0] == p.self {
+		return nil, false
+	}
+	owner := p.httpClients[candidates[0]]
+	read := p.boundedIndex(candidates)
+	p.Log("Pick peer from %s, key = %s", candidates[read], key)
+	if p.retryBudget != nil {
+		var backup PeerGetter
+		if read+1 < len(candidates) {
+			backup = p.getter(candidates[read+1])
+		}
+		return &hedgedGetter{pool: p, owner: owner, primary: p.getter(candidates[read]), backup: backup}, true
+	}
+	if read > 0 {
+		return &spilledGetter{owner: owner, read: p.getter(candidates[read])}, true
+	}
+	// 返回对应于这个请求地址的客户端实例
+	return owner, true
+}
+
+// getter 返回节点对应的 PeerGetter，本节点返回 localPeer，调用者需要持有 p.mu 的读锁
+func (p *HTTPPool) getter(addr string) PeerGetter {
+	if addr == p.self {
+		return localPeer{}
+	}
+	return p.httpClients[addr]
+}
+
+// PickReplicas 返回 key 在哈希环上连续 replicas 个节点中的远程节点，被摘除的节点不会收到写入
+func (p *HTTPPool) PickReplicas(key string) (peers []PeerGetter, self bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.peers == nil {
+		return nil, true
+	}
+	for _, addr := range p.peers.GetN(key, p.replicas) {
+		if addr == p.self {
+			self = true
+			continue
+		}
+		if client := p.httpClients[addr]; client.health.available() {
+			peers = append(peers, client)
+		}
+	}
+	return peers, self
+}
+
+// GetAll 返回除自己以外所有节点的 HTTP 客户端
+func (p *HTTPPool) GetAll() []PeerGetter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var peers []PeerGetter
+	for addr, client := range p.httpClients {
+		if addr != p.self {
+			peers = append(peers, client)
+		}
+	}
+	return peers
 }
 
 // 检查 HTTPPool 是否实现了 PeerPicker 的全部接口
 var _ PeerPicker = (*HTTPPool)(nil)
 
+// 检查 HTTPPool 是否实现了 ReplicaPicker
+var _ ReplicaPicker = (*HTTPPool)(nil)
+
+// 检查 HTTPPool 是否可以由 membership 在节点加入和离开时自动更新
+var _ membership.PeerUpdater = (*HTTPPool)(nil)
+
 // 实现客户端
 
 // httpGetter 客户端核心数据结构
 type httpClient struct {
-	baseUrl string // 表示将要访问的远程节点的地址
+	inFlight AtomicInt               // 正在进行的 Get 请求数，用于有界负载
+	baseUrl  string                  // 表示将要访问的远程节点的地址
+	timeout  time.Duration           // ctx 没有设置截止时间时使用的超时时间
+	health   *peerHealth             // 远程节点的健康状态，为 nil 时不跟踪
+	breaker  *circuitBreaker.Breaker // 远程节点的断路器，为 nil 时不使用断路器
+	peek     bool                    // Get 只读取远程节点已有的缓存值，用于迁移
 }
 
 // Get 实现了 PickGetter 的 Get 方法获取返回值并转化为 []byte 类型
-func (h *httpClient) Get(in *pb.Request, out *pb.Response) error {
-	//log.Printf("http.go: Get -> h.baseUrl = %v, group = %v, key = %v\n", h.baseUrl, url.QueryEscape(group), url.QueryEscape(key))
-	// 拼接要请求的 URL: 如 http://localhost:8001/_cache/ + groupName + key
-	u := fmt.Sprintf("%v%v/%v", h.baseUrl, url.QueryEscape(in.Group), url.QueryEscape(in.Key))
+func (h *httpClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
 	// 向服务端发起请求获取缓存值
-	res, err := http.Get(u)
+	bytes, err := h.do(ctx, http.MethodGet, in.Group, in.Key, nil)
 	// 请求失败，没有获取到对应的缓存
 	if err != nil {
 		return err
 	}
+	// 使用 proto.Unmarshal() 解码 HTTP 响应
+	if err = proto.Unmarshal(bytes, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// Set 使用 PUT 请求把缓存值写入远程节点
+func (h *httpClient) Set(ctx context.Context, in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	_, err = h.do(ctx, http.MethodPut, in.Group, in.Key, bytes.NewReader(body))
+	return err
+}
+
+// Remove 使用 DELETE 请求删除远程节点上的缓存值
+func (h *httpClient) Remove(ctx context.Context, in *pb.Request) error {
+	_, err := h.do(ctx, http.MethodDelete, in.Group, in.Key, nil)
+	return err
+}
+
+// do 向远程节点发起请求并读取响应体，响应状态码不是 2xx 时返回错误
+// ctx 没有截止时间时使用 h.timeout，有截止时间时把剩余的时间放在请求头中传给远程节点
+// 连接失败、超时、读取响应体失败和没有 pb.Code 的 502/503/504 记为远程节点的一次失败，调用者的截止时间到期也算，
+// 否则调用者都带有截止时间时没有响应的远程节点永远不会被摘除；只有调用者主动取消不算
+// 开启断路器时，断路器打开期间直接返回错误，不访问远程节点；调用者主动取消时只归还断路器的试探名额，不记录结果
+func (h *httpClient) do(ctx context.Context, method, group, key string, body io.Reader) ([]byte, error) {
+	var failure error // 说明远程节点有问题的错误，请求结束时报告给断路器
+	var canceled bool // 调用者主动取消，请求的结果不能说明远程节点是否正常
+	parent := ctx
+	if h.breaker != nil {
+		done, release, err := h.breaker.Acquire()
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %w", h.baseUrl, err)
+		}
+		defer func() {
+			if canceled {
+				release()
+				return
+			}
+			done(failure == nil)
+		}()
+	}
+	if _, ok := ctx.Deadline(); !ok && h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	// 拼接要请求的 URL: 如 http://localhost:8001/_cache/ + groupName + key
+	u := fmt.Sprintf("%v%v/%v", h.baseUrl, url.QueryEscape(group), url.QueryEscape(key))
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		req.Header.Set(timeoutHeader, strconv.FormatInt(ms, 10))
+	}
+	if h.peek {
+		req.Header.Set(peekHeader, "1")
+	}
+	if method == http.MethodGet {
+		req.Header.Set(forwardedHeader, "1")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if canceled = errors.Is(parent.Err(), context.Canceled); !canceled {
+			failure = err
+			h.health.failure(err)
+		}
+		return nil, err
+	}
 	defer res.Body.Close()
 	// 响应失败，返回响应错误信息
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = h.responseError(res)
+		// 500 是数据源返回的错误，带有 pb.Code 的 503/504 是远程节点正常处理后返回的数据源过载或超时，
+		// 都说明远程节点本身是正常的，只有没有类别的 502/503/504 记为远程节点的失败
+		var pe *PeerError
+		if res.StatusCode >= http.StatusBadGateway && !errors.As(err, &pe) {
+			failure = err
+			h.health.failure(failure)
+		} else {
+			h.health.success()
+		}
+		return nil, err
 	}
-
+	h.health.success()
 	// 把响应体的内容转化为 bytes 类型
 	bytes, err := ioutil.ReadAll(res.Body)
-	// 读取响应体失败
+	// 读取响应体失败，连接在中途断开，调用者主动取消时除外
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		if canceled = errors.Is(parent.Err(), context.Canceled); !canceled {
+			failure = err
+			h.health.failure(err)
+		}
+		return nil, fmt.Errorf("reading response body: %v", err)
 	}
-	// 使用 proto.Unmarshal() 解码 HTTP 响应
-	if err = proto.Unmarshal(bytes, out); err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+	return bytes, nil
+}
+
+// responseError 把失败的响应转换为错误，响应体是 proto 格式时还原为 PeerError，否则返回 statusError
+func (h *httpClient) responseError(res *http.Response) error {
+	if res.Header.Get("Content-Type") == "application/octet-stream" {
+		out := &pb.Response{}
+		if body, err := ioutil.ReadAll(res.Body); err == nil && proto.Unmarshal(body, out) == nil && out.Code != pb.Code_OK {
+			return &PeerError{Peer: h.baseUrl, Code: out.Code, Message: out.Message}
+		}
 	}
-	return nil
+	return &statusError{code: res.StatusCode, status: res.Status}
 }
 
 // 检查 httpClients 是否实现 PeerGetter 的全部的接口
@@ -0,0 +1,517 @@
+package distributedCache
+
+import (
+	"context"
+	"distributedCache/circuitBreaker"
+	"distributedCache/pb"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPSetRemove(t *testing.T) {
+	g := NewGroup("httpWrites", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	pool := NewHTTPPool("")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+	client := &httpClient{baseUrl: server.URL + defaultBasePath}
+
+	// PUT 写入后可以通过 GET 读到
+	if err := client.Set(context.Background(), &pb.SetRequest{Group: g.name, Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	out := &pb.Response{}
+	if err := client.Get(context.Background(), &pb.Request{Group: g.name, Key: "k"}, out); err != nil || string(out.Value) != "v" {
+		t.Fatalf("get after set failed: %v %q", err, out.Value)
+	}
+	// DELETE 之后 GET 回退到 Getter，返回错误
+	if err := client.Remove(context.Background(), &pb.Request{Group: g.name, Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(context.Background(), &pb.Request{Group: g.name, Key: "k"}, &pb.Response{}); err == nil {
+		t.Fatalf("key should be removed")
+	}
+}
+
+func TestHTTPDeadline(t *testing.T) {
+	deadlines := make(chan time.Duration, 1)
+	g := NewGroup("httpDeadline", 2<<10, GetterCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		// 远程节点的数据源能够拿到调用者传递过来的截止时间
+		if deadline, ok := ctx.Deadline(); ok {
+			deadlines <- time.Until(deadline)
+		}
+		return []byte(key), nil
+	}))
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	client := &httpClient{baseUrl: server.URL + defaultBasePath}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Get(ctx, &pb.Request{Group: g.name, Key: "k"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-deadlines:
+		if d <= 0 || d > time.Second {
+			t.Fatalf("remaining deadline %v not propagated", d)
+		}
+	default:
+		t.Fatalf("deadline not propagated to the peer")
+	}
+}
+
+func TestAddRemovePeers(t *testing.T) {
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", "http://a")
+	keys := make([]string, 1000)
+	owners := make(map[string]string, len(keys))
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		owners[keys[i]] = pool.peers.Get(keys[i])
+	}
+
+	// 并发地增删节点和选择节点
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			pool.AddPeers("http://b")
+			pool.RemovePeers("http://b")
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		pool.PickPeer(keys[i%len(keys)])
+	}
+	<-done
+
+	// 增加节点 b 后，只有属于 b 的 key 会移动
+	pool.AddPeers("http://b", "http://a")
+	if len(pool.httpClients) != 3 {
+		t.Fatalf("expect 3 clients, got %d", len(pool.httpClients))
+	}
+	for _, key := range keys {
+		if owner := pool.peers.Get(key); owner != owners[key] && owner != "http://b" {
+			t.Fatalf("key %s moved from %s to %s", key, owners[key], owner)
+		}
+	}
+	// 删除节点 b 后，所有 key 回到原来的节点
+	pool.RemovePeers("http://b")
+	for _, key := range keys {
+		if owner := pool.peers.Get(key); owner != owners[key] {
+			t.Fatalf("key %s should be back on %s, got %s", key, owners[key], owner)
+		}
+	}
+	if _, ok := pool.httpClients["http://b"]; ok {
+		t.Fatalf("client of removed peer still exists")
+	}
+}
+
+func TestPeerEjection(t *testing.T) {
+	// 远程节点正常时返回缓存值，down 为 1 时所有请求都返回 503
+	var down int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	pool := NewHTTPPool("http://self", WithHealthCheck(10*time.Millisecond), WithEjectionTime(time.Hour))
+	defer pool.Close()
+	pool.Set("http://self", server.URL)
+	var key string
+	for i := 0; ; i++ {
+		if _, ok := pool.PickPeer(strconv.Itoa(i)); ok {
+			key = strconv.Itoa(i)
+			break
+		}
+	}
+	peer, _ := pool.PickPeer(key)
+
+	// 被动检查：连续失败 3 次后被摘除，key 落到哈希环上的下一个节点，也就是自己
+	for i := 0; i < defaultFailureThreshold; i++ {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "g", Key: key}, &pb.Response{}); err == nil {
+			t.Fatal("expected error from unavailable peer")
+		}
+	}
+	if _, ok := pool.PickPeer(key); ok {
+		t.Fatal("ejected peer should be skipped")
+	}
+	stats := pool.Stats()
+	if len(stats.Peers) != 1 || stats.Peers[0].Healthy || stats.Ejections != 1 || stats.Peers[0].LastError == "" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 主动检查：节点恢复后 /healthz 成功，不用等到 ejectionTime 就重新被选择
+	atomic.StoreInt32(&down, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := pool.PickPeer(key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("recovered peer not picked again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := pool.Stats(); !stats.Peers[0].Healthy || stats.Peers[0].Failures != 0 {
+		t.Fatalf("unexpected stats after recovery %+v", stats)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	res, err := http.Get(server.URL + defaultBasePath + healthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("healthz returned %v", res.Status)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var transitions []string
+	pool := NewHTTPPool("http://self", WithFailureThreshold(100), WithCircuitBreaker(circuitBreaker.Settings{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Hour,
+		OnStateChange: func(name string, from, to circuitBreaker.State) {
+			transitions = append(transitions, name+" "+from.String()+"->"+to.String())
+		},
+	}))
+	pool.Set("http://self", server.URL)
+	client := pool.httpClients[server.URL]
+
+	for i := 0; i < 2; i++ {
+		if err := client.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, &pb.Response{}); err == nil {
+			t.Fatal("expected error from unavailable peer")
+		}
+	}
+	// 断路器打开后直接返回错误，不再访问远程节点
+	err := client.Get(context.Background(), &pb.Request{Group: "g", Key: "k"}, &pb.Response{})
+	if !errors.Is(err, circuitBreaker.ErrOpen) {
+		t.Fatalf("expected ErrOpen, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("open circuit should not reach the peer, got %d requests", n)
+	}
+	if len(transitions) != 1 || transitions[0] != server.URL+" closed->open" {
+		t.Fatalf("unexpected transitions %v", transitions)
+	}
+	if stats := pool.Stats(); stats.Peers[0].Circuit != "open" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	var hang int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&hang) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	pool := NewHTTPPool("http://self", WithFailureThreshold(100), WithCircuitBreaker(circuitBreaker.Settings{
+		FailureThreshold: 1,
+		OpenTimeout:      20 * time.Millisecond,
+	}))
+	pool.Set("http://self", server.URL)
+	client := pool.httpClients[server.URL]
+	get := func(ctx context.Context) error {
+		return client.Get(ctx, &pb.Request{Group: "g", Key: "k"}, &pb.Response{})
+	}
+	get(context.Background())
+	time.Sleep(30 * time.Millisecond)
+	if state := client.breaker.State(); state != circuitBreaker.StateHalfOpen {
+		t.Fatalf("breaker should be half-open, got %v", state)
+	}
+
+	// 调用者取消了试探请求，不能据此关闭断路器，试探名额归还给下一个请求
+	atomic.StoreInt32(&hang, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := get(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Canceled, got %v", err)
+	}
+	if state := client.breaker.State(); state != circuitBreaker.StateHalfOpen {
+		t.Fatalf("canceled probe changed the breaker to %v", state)
+	}
+	atomic.StoreInt32(&hang, 0)
+	if err := get(context.Background()); errors.Is(err, circuitBreaker.ErrTooManyRequests) {
+		t.Fatal("canceled probe did not release its slot")
+	}
+	if state := client.breaker.State(); state != circuitBreaker.StateOpen {
+		t.Fatalf("failed probe should reopen the breaker, got %v", state)
+	}
+}
+
+func TestCircuitBreakerHungPeer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	pool := NewHTTPPool("http://self", WithFailureThreshold(3), WithCircuitBreaker(circuitBreaker.Settings{
+		FailureThreshold: 3,
+		OpenTimeout:      time.Hour,
+	}))
+	pool.Set("http://self", server.URL)
+	client := pool.httpClients[server.URL]
+	// 远程节点一直不响应，调用者的截止时间到期也说明远程节点有问题，断路器打开，节点被摘除
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		client.Get(ctx, &pb.Request{Group: "g", Key: "k"}, &pb.Response{})
+		cancel()
+	}
+	if state := client.breaker.State(); state != circuitBreaker.StateOpen {
+		t.Fatalf("hung peer should open the breaker, got %v", state)
+	}
+	if stats := pool.Stats(); stats.Peers[0].Healthy || stats.Peers[0].Failures < 3 {
+		t.Fatalf("hung peer should be ejected: %+v", stats)
+	}
+}
+
+func TestPickReplicas(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithReplication(2))
+	pool.Set("http://self", "http://a", "http://b")
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		owners := pool.peers.GetN(key, 2)
+		peers, self := pool.PickReplicas(key)
+		// 副本是哈希环上连续的 2 个节点，其中可能包括自己
+		n := len(peers)
+		if self {
+			n++
+		}
+		if n != 2 || self != (owners[0] == "http://self" || owners[1] == "http://self") {
+			t.Fatalf("key %s: replicas %v self %v, owners %v", key, peers, self, owners)
+		}
+	}
+	// 副本数小于 1 时仍然写入 key 所属的节点
+	pool = NewHTTPPool("http://self", WithReplication(0))
+	pool.Set("http://self", "http://a")
+	for i := 0; i < 10; i++ {
+		key := strconv.Itoa(i)
+		if peers, self := pool.PickReplicas(key); len(peers)+btoi(self) != 1 {
+			t.Fatalf("key %s: replicas %v self %v, expect the owner", key, peers, self)
+		}
+	}
+}
+
+// btoi true 为 1，false 为 0
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestPeerWeights(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithPeerWeights(map[string]int{"http://big": 4}))
+	pool.Set("http://self", "http://big", "http://small")
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = pool.peers.Get(key)
+		counts[owners[key]]++
+	}
+	if counts["http://big"] < counts["http://small"]*3/2 || counts["http://big"] < counts["http://self"]*3/2 {
+		t.Fatalf("weighted peer should own more keys: %v", counts)
+	}
+	// 增量删除再添加时仍然使用权重，key 回到原来的节点
+	pool.RemovePeers("http://big")
+	pool.AddPeers("http://big")
+	for key, owner := range owners {
+		if got := pool.peers.Get(key); got != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, got)
+		}
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	for name, newSelector := range map[string]SelectorFactory{
+		"ring":       RingSelector,
+		"rendezvous": RendezvousSelector,
+		"jump":       JumpSelector,
+		"maglev":     MaglevSelector,
+	} {
+		pool := NewHTTPPool("http://self", WithSelector(newSelector))
+		pool.Set("http://self", "http://a", "http://b")
+		picked := make(map[string]int)
+		for i := 0; i < 300; i++ {
+			key := strconv.Itoa(i)
+			owner := pool.peers.Get(key)
+			peer, ok := pool.PickPeer(key)
+			// key 属于自己时返回 false，否则返回 owner 的客户端
+			if ok != (owner != "http://self") || ok && peer != pool.httpClients[owner] {
+				t.Fatalf("%s: key %s owned by %s, PickPeer returned %v %v", name, key, owner, peer, ok)
+			}
+			picked[owner]++
+		}
+		if len(picked) != 3 {
+			t.Fatalf("%s: keys not spread over all peers: %v", name, picked)
+		}
+	}
+}
+
+func TestJumpMembershipOrder(t *testing.T) {
+	// 各节点收到成员变化的顺序不同，只要最终的节点相同，jump 的编号就相同
+	gossip := NewHTTPPool("http://self", WithSelector(JumpSelector))
+	for _, addr := range []string{"http://c", "http://a", "http://self", "http://d", "http://b"} {
+		gossip.AddPeers(addr)
+	}
+	gossip.RemovePeers("http://a", "http://d")
+	gossip.AddPeers("http://a")
+	static := NewHTTPPool("http://self", WithSelector(JumpSelector))
+	static.Set("http://b", "http://self", "http://a", "http://c")
+	handoff := NewHTTPPool("http://self", WithSelector(JumpSelector), WithHandoff(1000))
+	handoff.AddPeers("http://self", "http://c", "http://b", "http://a")
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if a, b, c := gossip.peers.Get(key), static.peers.Get(key), handoff.peers.Get(key); a != b || b != c {
+			t.Fatalf("key %s owned by %s, %s and %s", key, a, b, c)
+		}
+	}
+}
+
+func TestJumpSortedMovement(t *testing.T) {
+	addrs := make([]string, 10)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("http://cache-%02d", i)
+	}
+	moved := func(addr string) float64 {
+		pool := NewHTTPPool("http://cache-00", WithSelector(JumpSelector))
+		pool.Set(addrs...)
+		before := make([]string, 1000)
+		for i := range before {
+			before[i] = pool.peers.Get(strconv.Itoa(i))
+		}
+		pool.AddPeers(addr)
+		n := 0
+		for i := range before {
+			if pool.peers.Get(strconv.Itoa(i)) != before[i] {
+				n++
+			}
+		}
+		return float64(n) / float64(len(before))
+	}
+	// 新节点排在最后时只移动大约 1/11 的 key，排在最前面时所有节点的编号都变了，大部分 key 都会移动
+	if share := moved("http://cache-10"); share > 1.2/11 {
+		t.Fatalf("%.3f of keys moved after appending a node", share)
+	}
+	if share := moved("http://cache-0"); share < 0.8 {
+		t.Fatalf("%.3f of keys moved after inserting a node first, expect most keys", share)
+	}
+}
+
+func TestTypedErrorsKeepPeerHealthy(t *testing.T) {
+	g := NewGroup("typedHealthy", 2<<10, GetterCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if key == "slow" {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, fmt.Errorf("too many queries: %w", ErrOverloaded)
+	}))
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	pool := NewHTTPPool("http://self", WithFailureThreshold(1), WithCircuitBreaker(circuitBreaker.Settings{FailureThreshold: 1}))
+	pool.Set("http://self", server.URL)
+	client := pool.httpClients[server.URL]
+
+	// 数据源过载或超时时远程节点返回带有类别的 503/504，远程节点本身是正常的，不能被摘除或打开断路器
+	for _, key := range []string{"busy", "slow", "busy"} {
+		err := client.Get(context.Background(), &pb.Request{Group: g.name, Key: key}, &pb.Response{})
+		if !errors.Is(err, ErrOverloaded) && !errors.Is(err, ErrTimeout) {
+			t.Fatalf("Get(%s) = %v", key, err)
+		}
+	}
+	if !client.health.available() || client.breaker.State() != circuitBreaker.StateClosed {
+		t.Fatalf("healthy peer was ejected or tripped: %+v", pool.Stats())
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	g := NewGroup("httpNotFound", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}))
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	client := &httpClient{baseUrl: server.URL + defaultBasePath}
+
+	// 数据源中没有的 key 返回 404，客户端还原为 ErrNotFound
+	if err := client.Get(context.Background(), &pb.Request{Group: g.name, Key: "k"}, &pb.Response{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get = %v, expect ErrNotFound", err)
+	}
+	// group 不存在同样是 404，但不是 ErrNotFound
+	err := client.Get(context.Background(), &pb.Request{Group: "no-such-group", Key: "k"}, &pb.Response{})
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get from unknown group = %v", err)
+	}
+}
+
+func TestTypedPeerErrors(t *testing.T) {
+	g := NewGroup("httpErrors", 2<<10, GetterCtxFunc(func(ctx context.Context, key string) ([]byte, error) {
+		switch key {
+		case "missing":
+			return nil, ErrNotFound
+		case "busy":
+			return nil, fmt.Errorf("too many queries: %w", ErrOverloaded)
+		case "slow":
+			// 远程节点自己的截止时间先到了
+			return nil, context.DeadlineExceeded
+		default:
+			return nil, errors.New("db is down")
+		}
+	}))
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	client := &httpClient{baseUrl: server.URL + defaultBasePath}
+
+	tests := []struct {
+		key    string
+		expect error
+		local  bool // 是否应该回退到本地的数据源
+	}{
+		{"missing", ErrNotFound, false},
+		{"", ErrBadRequest, false},
+		{"busy", ErrOverloaded, true},
+		{"slow", ErrTimeout, true},
+		{"broken", ErrInternal, false},
+	}
+	for _, tt := range tests {
+		err := client.Get(context.Background(), &pb.Request{Group: g.name, Key: tt.key}, &pb.Response{})
+		var pe *PeerError
+		if !errors.As(err, &pe) || !errors.Is(err, tt.expect) {
+			t.Fatalf("Get(%q) = %v, expect %v", tt.key, err, tt.expect)
+		}
+		if retryLocally(err) != tt.local {
+			t.Fatalf("retryLocally(%v) = %v", err, !tt.local)
+		}
+	}
+	// 连接失败时回退到本地
+	if !retryLocally(errors.New("connection refused")) {
+		t.Fatal("transport errors should fall back to the local getter")
+	}
+}
@@ -2,6 +2,8 @@ package lru
 
 import (
 	"container/list"
+	"distributedCache/eviction"
+	"time"
 )
 
 // CacheLRU LRU 缓存，并发访问是不安全的。
@@ -15,15 +17,19 @@ type CacheLRU struct {
 
 // node 键值对 node 是双向链表节点的数据类型
 type node struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
 }
 
-// Value 为了通用性，值是实现了 Value 接口的任意类型，该接口只包含了一个方法 Len() int，用于返回值所占用的内存大小
-type Value interface {
-	Len() int
+// expired 判断节点在 now 时刻是否已经过期
+func (n *node) expired(now time.Time) bool {
+	return !n.expire.IsZero() && now.After(n.expire)
 }
 
+// Value 为了通用性，值是实现了 Value 接口的任意类型，该接口只包含了一个方法 Len() int，用于返回值所占用的内存大小
+type Value = eviction.Value
+
 // New 实例化一个 CacheLRU
 func New(maxBytes int64, OnEvicted func(key string, value Value)) *CacheLRU {
 	return &CacheLRU{
@@ -35,49 +41,99 @@ func New(maxBytes int64, OnEvicted func(key string, value Value)) *CacheLRU {
 }
 
 // Find 实现查找功能,第一步是从字典中找到对应的双向链表的节点，第二步，将该节点移动到队尾
+// 已经过期的节点视为未命中，并顺便将其删除
 func (c *CacheLRU) Find(key string) (value Value, ok bool) {
 	if elem, ok := c.cacheMap[key]; ok {
+		kv := elem.Value.(*node)
+		if kv.expired(time.Now()) {
+			c.removeElement(elem)
+			return nil, false
+		}
 		// 双向链表作为队列，队首队尾是相对的，在这里约定 Back 为队尾
 		c.ll.MoveToBack(elem)
 		// 返回节点值
-		kv := elem.Value.(*node)
 		return kv.value, true
 	}
 	return
 }
 
+// Peek 查找节点，不移动节点在队列中的位置，过期的节点视为未命中但不删除
+func (c *CacheLRU) Peek(key string) (value Value, ok bool) {
+	if elem, ok := c.cacheMap[key]; ok {
+		kv := elem.Value.(*node)
+		if !kv.expired(time.Now()) {
+			return kv.value, true
+		}
+	}
+	return
+}
+
 // Remove 移除最近最少访问的节点,也就是队首的元素
 func (c *CacheLRU) Remove() {
 	// 双向链表作为队列，队首队尾是相对的，在这里约定 Back 为队尾, Front为队首
 	elem := c.ll.Front()
 	if elem != nil {
-		//fmt.Printf("elem = %v\n", elem.Value)
-		// 从双向列表中移除
-		c.ll.Remove(elem)
-		kv := elem.Value.(*node)
-		// 删除这个节点
-		delete(c.cacheMap, kv.key)
-		// 更新当前已使用内存
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		// 如果有回调函数则调用
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(elem)
+	}
+}
+
+// Delete 删除指定 key 的节点，返回节点是否存在
+func (c *CacheLRU) Delete(key string) bool {
+	if elem, ok := c.cacheMap[key]; ok {
+		c.removeElement(elem)
+		return true
+	}
+	return false
+}
+
+// RemoveExpired 移除所有已经过期的节点，返回移除的数量
+func (c *CacheLRU) RemoveExpired() int {
+	now := time.Now()
+	count := 0
+	for elem := c.ll.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*node).expired(now) {
+			c.removeElement(elem)
+			count++
 		}
+		elem = next
 	}
+	return count
 }
 
-// Add 新增一个节点
+// removeElement 从双向链表和 map 中删除节点，更新内存使用并调用回调函数
+func (c *CacheLRU) removeElement(elem *list.Element) {
+	// 从双向列表中移除
+	c.ll.Remove(elem)
+	kv := elem.Value.(*node)
+	// 删除这个节点
+	delete(c.cacheMap, kv.key)
+	// 更新当前已使用内存
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	// 如果有回调函数则调用
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// Add 新增一个永不过期的节点
 func (c *CacheLRU) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增一个节点，expire 为过期时间，零值表示永不过期
+func (c *CacheLRU) AddWithExpire(key string, value Value, expire time.Time) {
 	// 如果这个节点已经存在则修改并移动到队尾
 	if elem, ok := c.cacheMap[key]; ok {
 		c.ll.MoveToBack(elem)
 		kv := elem.Value.(*node)
-		// 计算已使用内存情况
-		c.nbytes += int64(len(kv.key)) + int64(kv.value.Len())
+		// 计算已使用内存情况，只需要加上新旧值的差值
+		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
+		kv.expire = expire
 	} else {
 		// 节点不存在则创建并添加到队尾
-		elem := c.ll.PushBack(&node{key: key, value: value})
+		elem := c.ll.PushBack(&node{key: key, value: value, expire: expire})
 		c.cacheMap[key] = elem
 		c.nbytes += int64(len(key)) + int64(value.Len())
 	}
@@ -91,3 +147,23 @@ func (c *CacheLRU) Add(key string, value Value) {
 func (c *CacheLRU) GetRecord() int {
 	return c.ll.Len()
 }
+
+// Keys 返回所有没有过期的节点的 key，从最近最少访问的开始，不移动节点
+func (c *CacheLRU) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		if kv := elem.Value.(*node); !kv.expired(now) {
+			keys = append(keys, kv.key)
+		}
+	}
+	return keys
+}
+
+// Bytes 返回当前已使用的内存
+func (c *CacheLRU) Bytes() int64 {
+	return c.nbytes
+}
+
+// 检查 CacheLRU 是否实现了 eviction.Policy 的全部接口
+var _ eviction.Policy = (*CacheLRU)(nil)
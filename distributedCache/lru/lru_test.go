@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -37,6 +38,27 @@ func TestCacheLRU_Remove(t *testing.T) {
 	}
 }
 
+func TestCacheLRU_Peek(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	lru := New(int64(len(k1+k2+v1+v2)), nil)
+	lru.Add(k1, String(v1))
+	lru.Add(k2, String(v2))
+	// Peek 不移动节点，key1 仍然是最先被淘汰的
+	if v, ok := lru.Peek(k1); !ok || string(v.(String)) != v1 {
+		t.Fatalf("peek key1 failed")
+	}
+	lru.Add(k3, String(v3))
+	if _, ok := lru.Peek(k1); ok {
+		t.Fatalf("peek should not protect key1 from eviction")
+	}
+	lru = New(int64(0), nil)
+	lru.AddWithExpire("expired", String("v"), time.Now().Add(-time.Second))
+	if _, ok := lru.Peek("expired"); ok || lru.GetRecord() != 1 {
+		t.Fatalf("peek should miss expired nodes without removing them")
+	}
+}
+
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
 	callback := func(key string, value Value) {
@@ -58,3 +80,41 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestCacheLRU_Expire(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(time.Hour))
+	// 过期的节点视为未命中并被删除
+	if _, ok := lru.Find("key1"); ok || lru.GetRecord() != 1 {
+		t.Fatalf("expired key1 should be a miss")
+	}
+	if v, ok := lru.Find("key2"); !ok || string(v.(String)) != "5678" {
+		t.Fatalf("cache hit key2=5678 failed")
+	}
+}
+
+func TestCacheLRU_RemoveExpired(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	lru.Add("k3", String("v3"))
+	if n := lru.RemoveExpired(); n != 2 || lru.GetRecord() != 1 {
+		t.Fatalf("RemoveExpired removed %d, expect 2", n)
+	}
+	// 过期节点被清理后不再占用内存
+	if lru.Bytes() != int64(len("k3")+len("v3")) {
+		t.Fatalf("bytes = %d after RemoveExpired", lru.Bytes())
+	}
+}
+
+func TestCacheLRU_Keys(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("k1", String("v1"))
+	lru.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	lru.Add("k3", String("v3"))
+	// 过期的节点不返回，也不会被删除
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"k1", "k3"}) || lru.GetRecord() != 3 {
+		t.Fatalf("Keys() = %v", keys)
+	}
+}
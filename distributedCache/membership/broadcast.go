@@ -0,0 +1,50 @@
+package membership
+
+import (
+	"math"
+	"sort"
+)
+
+// 实现 gossip 的传播队列：节点状态的变化附带在正常的探测消息上传播，每条消息重传有限次数
+
+// broadcast 一条待传播的节点状态
+type broadcast struct {
+	member    Member
+	transmits int // 已经发送的次数
+}
+
+// broadcastQueue 待传播的节点状态队列，并发访问是不安全的，由 Memberlist 加锁
+type broadcastQueue struct {
+	items []*broadcast
+}
+
+// enqueue 加入一条节点状态，同一个节点旧的状态会被新的状态替换
+func (q *broadcastQueue) enqueue(m Member) {
+	for i, b := range q.items {
+		if b.member.Name == m.Name {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.items = append(q.items, &broadcast{member: m})
+}
+
+// next 取出最多 limit 条发送次数最少的节点状态，numNodes 是集群的节点数，
+// 每条状态最多发送 retransmitMult*ceil(log10(numNodes+1)) 次，足够让它传遍整个集群
+func (q *broadcastQueue) next(limit, retransmitMult, numNodes int) []Member {
+	maxTransmits := retransmitMult * int(math.Ceil(math.Log10(float64(numNodes+1))))
+	sort.SliceStable(q.items, func(i, j int) bool { return q.items[i].transmits < q.items[j].transmits })
+	var out []Member
+	kept := q.items[:0]
+	for _, b := range q.items {
+		if len(out) < limit {
+			out = append(out, b.member)
+			b.transmits++
+		}
+		if b.transmits < maxTransmits {
+			kept = append(kept, b)
+		}
+	}
+	q.items = kept
+	return out
+}
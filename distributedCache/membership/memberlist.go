@@ -0,0 +1,521 @@
+package membership
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 基于 SWIM 协议实现的集群成员管理与故障检测：
+// 每个探测周期随机选择一个节点发送 ping，超时没有收到 ack 时请其它几个节点帮忙间接 ping，
+// 仍然失败则把它标记为 suspect 并在集群中传播，suspect 的节点在超时之前没有反驳就被判定为 dead。
+// 节点状态的变化附带在探测消息上以 gossip 的方式传播，不需要额外的广播消息
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 500 * time.Millisecond
+	defaultIndirectChecks   = 3
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultRetransmitMult   = 4
+	maxPiggyback            = 8     // 每条消息最多附带的节点状态数
+	maxPacketSize           = 65536 // UDP 包的最大长度
+)
+
+// PeerUpdater 节点加入和离开集群时需要通知的对象，HTTPPool 实现了这个接口
+type PeerUpdater interface {
+	AddPeers(peers ...string)
+	RemovePeers(peers ...string)
+}
+
+// Config Memberlist 的配置，零值的字段使用默认值
+type Config struct {
+	Name             string        // 本节点的名称，通常是缓存节点的 HTTP 地址
+	BindAddr         string        // 监听 gossip 消息的 UDP 地址，例如 localhost:7946，端口为 0 时随机选择
+	ProbeInterval    time.Duration // 探测周期
+	ProbeTimeout     time.Duration // 直接 ping 等待 ack 的时间，超时后发起间接 ping
+	IndirectChecks   int           // 间接 ping 时请求帮忙的节点数
+	SuspicionTimeout time.Duration // suspect 的节点经过多久没有反驳被判定为 dead
+	RetransmitMult   int           // 每条节点状态的重传倍数
+
+	Peers   PeerUpdater                  // 节点加入和离开时自动更新，可以为空
+	OnJoin  func(m Member)               // 节点加入集群时的回调，可以为空
+	OnLeave func(m Member)               // 节点故障或离开集群时的回调，可以为空
+	Logf    func(string, ...interface{}) // 日志函数，为空时使用 log.Printf
+}
+
+// msgType 消息类型
+type msgType string
+
+const (
+	pingMsg    msgType = "ping"     // 直接探测
+	ackMsg     msgType = "ack"      // 探测的应答
+	pingReqMsg msgType = "ping-req" // 请求其它节点帮忙探测 Target
+	joinMsg    msgType = "join"     // 加入集群，收到后回复 syncMsg
+	syncMsg    msgType = "sync"     // 全部节点的状态
+	gossipMsg  msgType = "gossip"   // 只传播节点状态
+)
+
+// message 节点之间传递的消息，编码为 JSON 通过 UDP 发送
+type message struct {
+	Type    msgType  `json:"type"`
+	Seq     uint64   `json:"seq,omitempty"`
+	From    string   `json:"from"`             // 发送者的 UDP 地址
+	Target  string   `json:"target,omitempty"` // ping-req 要探测的节点的 UDP 地址
+	Updates []Member `json:"updates,omitempty"`
+}
+
+// Memberlist 一个集群成员，维护其它节点的状态
+type Memberlist struct {
+	config Config
+	conn   *net.UDPConn
+	addr   string // 实际监听的 UDP 地址
+
+	mu          sync.Mutex
+	self        *Member
+	members     map[string]*Member // 节点名称 -> 节点，包括自己和已经 dead 的节点
+	probeOrder  []string           // 本轮探测的顺序，每轮重新打乱
+	probeIndex  int
+	broadcasts  broadcastQueue
+	suspicions  map[string]*time.Timer
+	ackHandlers map[uint64]func()
+
+	seq        uint64 // 原子操作
+	shutdownCh chan struct{}
+	shutdown   sync.Once
+	wg         sync.WaitGroup
+}
+
+// Create 创建集群成员并开始监听和探测，此时集群中只有自己，需要调用 Join 加入已有的集群
+func Create(config Config) (*Memberlist, error) {
+	if config.Name == "" {
+		return nil, errors.New("membership: Name is required")
+	}
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = defaultProbeInterval
+	}
+	if config.ProbeTimeout <= 0 {
+		config.ProbeTimeout = defaultProbeTimeout
+	}
+	if config.ProbeTimeout > config.ProbeInterval {
+		config.ProbeTimeout = config.ProbeInterval
+	}
+	if config.IndirectChecks <= 0 {
+		config.IndirectChecks = defaultIndirectChecks
+	}
+	if config.SuspicionTimeout <= 0 {
+		config.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if config.RetransmitMult <= 0 {
+		config.RetransmitMult = defaultRetransmitMult
+	}
+	if config.Logf == nil {
+		config.Logf = log.Printf
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", config.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	m := &Memberlist{
+		config: config,
+		conn:   conn,
+		addr:   conn.LocalAddr().String(),
+		// 版本号从当前时间开始，节点重启之后的 alive 消息一定比它上次的 dead 状态新
+		self: &Member{
+			Name:        config.Name,
+			Addr:        conn.LocalAddr().String(),
+			State:       StateAlive,
+			Incarnation: uint64(time.Now().UnixNano()),
+		},
+		members:     make(map[string]*Member),
+		suspicions:  make(map[string]*time.Timer),
+		ackHandlers: make(map[uint64]func()),
+		shutdownCh:  make(chan struct{}),
+	}
+	m.members[config.Name] = m.self
+	m.broadcasts.enqueue(*m.self)
+	m.notify(*m.self, true)
+
+	m.wg.Add(2)
+	go m.readLoop()
+	go m.probeLoop()
+	return m, nil
+}
+
+// LocalAddr 返回实际监听的 UDP 地址
+func (m *Memberlist) LocalAddr() string {
+	return m.addr
+}
+
+// LocalMember 返回本节点
+func (m *Memberlist) LocalMember() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.self
+}
+
+// Members 返回集群中存活（alive 或 suspect）的节点，包括自己，按名称排序
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]Member, 0, len(m.members))
+	for _, mem := range m.members {
+		if !mem.down() {
+			members = append(members, *mem)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// Join 向种子节点发送加入请求，只要有一个种子节点应答就算成功
+func (m *Memberlist) Join(seeds ...string) error {
+	if len(seeds) == 0 {
+		return nil
+	}
+	synced := make(chan struct{}, len(seeds))
+	seqs := make([]uint64, 0, len(seeds))
+	for _, seed := range seeds {
+		seq := m.nextSeq()
+		seqs = append(seqs, seq)
+		m.setAckHandler(seq, func() {
+			select {
+			case synced <- struct{}{}:
+			default:
+			}
+		})
+		if err := m.send(seed, message{Type: joinMsg, Seq: seq, Updates: []Member{m.LocalMember()}}); err != nil {
+			m.config.Logf("[Membership] join %s: %v", seed, err)
+		}
+	}
+	defer func() {
+		for _, seq := range seqs {
+			m.deleteAckHandler(seq)
+		}
+	}()
+	select {
+	case <-synced:
+		return nil
+	case <-time.After(m.config.ProbeInterval + m.config.ProbeTimeout):
+		return fmt.Errorf("membership: no response from seeds %v", seeds)
+	case <-m.shutdownCh:
+		return errors.New("membership: shut down")
+	}
+}
+
+// Leave 通知集群本节点主动离开，之后应该调用 Shutdown
+func (m *Memberlist) Leave() {
+	m.mu.Lock()
+	m.self.Incarnation++
+	m.self.State = StateLeft
+	left := *m.self
+	var addrs []string
+	for _, mem := range m.members {
+		if mem != m.self && !mem.down() {
+			addrs = append(addrs, mem.Addr)
+		}
+	}
+	m.mu.Unlock()
+	for _, addr := range addrs {
+		_ = m.send(addr, message{Type: gossipMsg, Updates: []Member{left}})
+	}
+}
+
+// Shutdown 停止探测并关闭 UDP 连接，不通知其它节点，其它节点会通过探测发现本节点故障
+func (m *Memberlist) Shutdown() {
+	m.shutdown.Do(func() {
+		close(m.shutdownCh)
+		_ = m.conn.Close()
+		m.wg.Wait()
+		m.mu.Lock()
+		for name, t := range m.suspicions {
+			t.Stop()
+			delete(m.suspicions, name)
+		}
+		m.mu.Unlock()
+	})
+}
+
+// probeLoop 每个探测周期探测一个节点
+func (m *Memberlist) probeLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.probe()
+		case <-m.shutdownCh:
+			return
+		}
+	}
+}
+
+// probe 探测一个节点：先直接 ping，超时后请其它节点间接 ping，到探测周期结束还没有 ack 就把它标记为 suspect
+func (m *Memberlist) probe() {
+	target, ok := m.nextProbeTarget()
+	if !ok {
+		return
+	}
+	acked := make(chan struct{}, 1)
+	seq := m.nextSeq()
+	m.setAckHandler(seq, func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	defer m.deleteAckHandler(seq)
+
+	_ = m.send(target.Addr, message{Type: pingMsg, Seq: seq})
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.config.ProbeTimeout):
+	case <-m.shutdownCh:
+		return
+	}
+
+	for _, relay := range m.randomMembers(m.config.IndirectChecks, target.Name) {
+		_ = m.send(relay.Addr, message{Type: pingReqMsg, Seq: seq, Target: target.Addr})
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.config.ProbeInterval - m.config.ProbeTimeout):
+	case <-m.shutdownCh:
+		return
+	}
+	m.config.Logf("[Membership] probe %s failed, marking it suspect", target.Name)
+	m.update(Member{Name: target.Name, State: StateSuspect, Incarnation: target.Incarnation})
+}
+
+// nextProbeTarget 按照打乱后的顺序轮流选择要探测的节点，保证每个节点在有限时间内一定会被探测到
+func (m *Memberlist) nextProbeTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tries := 0; tries < 2; tries++ {
+		for m.probeIndex < len(m.probeOrder) {
+			mem, ok := m.members[m.probeOrder[m.probeIndex]]
+			m.probeIndex++
+			if ok && mem != m.self && !mem.down() {
+				return *mem, true
+			}
+		}
+		m.probeOrder = m.probeOrder[:0]
+		for name := range m.members {
+			m.probeOrder = append(m.probeOrder, name)
+		}
+		rand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+		m.probeIndex = 0
+	}
+	return Member{}, false
+}
+
+// randomMembers 随机选择最多 k 个存活的其它节点，不包括 exclude
+func (m *Memberlist) randomMembers(k int, exclude string) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []Member
+	for _, mem := range m.members {
+		if mem != m.self && mem.Name != exclude && mem.State == StateAlive {
+			candidates = append(candidates, *mem)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+// readLoop 接收并处理其它节点的消息
+func (m *Memberlist) readLoop() {
+	defer m.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.shutdownCh:
+				return
+			default:
+			}
+			m.config.Logf("[Membership] read: %v", err)
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			m.config.Logf("[Membership] bad message: %v", err)
+			continue
+		}
+		m.handle(msg)
+	}
+}
+
+// handle 处理一条消息
+func (m *Memberlist) handle(msg message) {
+	for _, u := range msg.Updates {
+		m.update(u)
+	}
+	switch msg.Type {
+	case pingMsg:
+		_ = m.send(msg.From, message{Type: ackMsg, Seq: msg.Seq})
+	case ackMsg:
+		m.mu.Lock()
+		fn := m.ackHandlers[msg.Seq]
+		m.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	case pingReqMsg:
+		// 代替 msg.From 探测 msg.Target，收到 ack 后以原来的序号转发给 msg.From
+		seq := m.nextSeq()
+		from, origSeq := msg.From, msg.Seq
+		m.setAckHandler(seq, func() {
+			_ = m.send(from, message{Type: ackMsg, Seq: origSeq})
+		})
+		time.AfterFunc(m.config.ProbeInterval, func() { m.deleteAckHandler(seq) })
+		_ = m.send(msg.Target, message{Type: pingMsg, Seq: seq})
+	case joinMsg:
+		m.mu.Lock()
+		all := make([]Member, 0, len(m.members))
+		for _, mem := range m.members {
+			all = append(all, *mem)
+		}
+		m.mu.Unlock()
+		_ = m.send(msg.From, message{Type: syncMsg, Seq: msg.Seq, Updates: all})
+	case syncMsg:
+		m.mu.Lock()
+		fn := m.ackHandlers[msg.Seq]
+		m.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	}
+}
+
+// update 合并一条节点状态，状态发生变化时继续传播并通知 Peers 和回调
+func (m *Memberlist) update(u Member) {
+	if u.Name == "" {
+		return
+	}
+	m.mu.Lock()
+	if u.Name == m.self.Name {
+		// 其它节点怀疑本节点故障，增加版本号反驳
+		if (u.State == StateSuspect || u.State == StateDead) && u.Incarnation >= m.self.Incarnation && m.self.State == StateAlive {
+			m.self.Incarnation = u.Incarnation + 1
+			m.broadcasts.enqueue(*m.self)
+		}
+		m.mu.Unlock()
+		return
+	}
+	mem, ok := m.members[u.Name]
+	if !ok {
+		// 只有 alive 状态的未知节点才会被加入，未知节点的其它状态没有意义
+		if u.State != StateAlive {
+			m.mu.Unlock()
+			return
+		}
+		mem = &Member{Name: u.Name, State: StateLeft}
+		m.members[u.Name] = mem
+	}
+	wasDown := mem.down()
+	if !ok {
+		// 新节点直接接受
+		mem.State, mem.Incarnation, mem.Addr = u.State, u.Incarnation, u.Addr
+	} else if !mem.apply(u) {
+		m.mu.Unlock()
+		return
+	}
+	m.broadcasts.enqueue(*mem)
+	if t := m.suspicions[mem.Name]; t != nil {
+		t.Stop()
+		delete(m.suspicions, mem.Name)
+	}
+	if mem.State == StateSuspect {
+		suspect := *mem
+		m.suspicions[mem.Name] = time.AfterFunc(m.config.SuspicionTimeout, func() {
+			m.config.Logf("[Membership] %s did not refute suspicion, marking it dead", suspect.Name)
+			m.update(Member{Name: suspect.Name, State: StateDead, Incarnation: suspect.Incarnation})
+		})
+	}
+	changed := *mem
+	m.mu.Unlock()
+
+	switch {
+	case wasDown && !changed.down():
+		m.notify(changed, true)
+	case !wasDown && changed.down():
+		m.notify(changed, false)
+	}
+}
+
+// notify 通知 Peers 和回调节点加入或离开
+func (m *Memberlist) notify(mem Member, joined bool) {
+	if joined {
+		m.config.Logf("[Membership] %s (%s) joined", mem.Name, mem.Addr)
+		if m.config.Peers != nil {
+			m.config.Peers.AddPeers(mem.Name)
+		}
+		if m.config.OnJoin != nil {
+			m.config.OnJoin(mem)
+		}
+		return
+	}
+	m.config.Logf("[Membership] %s (%s) is %s", mem.Name, mem.Addr, mem.State)
+	if m.config.Peers != nil {
+		m.config.Peers.RemovePeers(mem.Name)
+	}
+	if m.config.OnLeave != nil {
+		m.config.OnLeave(mem)
+	}
+}
+
+// send 发送一条消息，除了 sync 消息之外都会附带待传播的节点状态
+func (m *Memberlist) send(addr string, msg message) error {
+	msg.From = m.addr
+	if msg.Type != syncMsg {
+		m.mu.Lock()
+		msg.Updates = append(msg.Updates, m.broadcasts.next(maxPiggyback, m.config.RetransmitMult, len(m.members))...)
+		m.mu.Unlock()
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = m.conn.WriteToUDP(b, udpAddr)
+	return err
+}
+
+func (m *Memberlist) nextSeq() uint64 {
+	return atomic.AddUint64(&m.seq, 1)
+}
+
+func (m *Memberlist) setAckHandler(seq uint64, fn func()) {
+	m.mu.Lock()
+	m.ackHandlers[seq] = fn
+	m.mu.Unlock()
+}
+
+func (m *Memberlist) deleteAckHandler(seq uint64) {
+	m.mu.Lock()
+	delete(m.ackHandlers, seq)
+	m.mu.Unlock()
+}
@@ -0,0 +1,170 @@
+package membership
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePeers 记录 Memberlist 通知的节点
+type fakePeers struct {
+	mu    sync.Mutex
+	peers map[string]bool
+}
+
+func (f *fakePeers) AddPeers(peers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range peers {
+		f.peers[p] = true
+	}
+}
+
+func (f *fakePeers) RemovePeers(peers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range peers {
+		delete(f.peers, p)
+	}
+}
+
+func (f *fakePeers) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []string
+	for p := range f.peers {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func newTestNode(t *testing.T, i int, opts ...func(*Config)) (*Memberlist, *fakePeers) {
+	t.Helper()
+	peers := &fakePeers{peers: make(map[string]bool)}
+	config := Config{
+		Name:             fmt.Sprintf("http://node%d", i),
+		BindAddr:         "127.0.0.1:0",
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     10 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		Peers:            peers,
+		Logf:             func(string, ...interface{}) {},
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	m, err := Create(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Shutdown)
+	return m, peers
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJoinAndFail(t *testing.T) {
+	var nodes []*Memberlist
+	var peers []*fakePeers
+	for i := 0; i < 4; i++ {
+		m, p := newTestNode(t, i)
+		nodes, peers = append(nodes, m), append(peers, p)
+	}
+	for _, m := range nodes[1:] {
+		if err := m.Join(nodes[0].LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := []string{"http://node0", "http://node1", "http://node2", "http://node3"}
+	for i, p := range peers {
+		waitFor(t, fmt.Sprintf("node%d to see all nodes", i), func() bool {
+			return equal(p.list(), all) && len(nodes[i].Members()) == len(all)
+		})
+	}
+
+	// node3 崩溃，其它节点应该通过探测发现并把它移除
+	nodes[3].Shutdown()
+	for i, p := range peers[:3] {
+		waitFor(t, fmt.Sprintf("node%d to remove node3", i), func() bool {
+			return equal(p.list(), all[:3])
+		})
+	}
+}
+
+func TestLeave(t *testing.T) {
+	var left []Member
+	var mu sync.Mutex
+	a, peersA := newTestNode(t, 0, func(c *Config) {
+		c.OnLeave = func(m Member) {
+			mu.Lock()
+			left = append(left, m)
+			mu.Unlock()
+		}
+	})
+	b, _ := newTestNode(t, 1)
+	if err := b.Join(a.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "b to join", func() bool { return len(peersA.list()) == 2 })
+
+	b.Leave()
+	waitFor(t, "b to leave", func() bool { return equal(peersA.list(), []string{"http://node0"}) })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(left) != 1 || left[0].Name != "http://node1" || left[0].State != StateLeft {
+		t.Fatalf("OnLeave got %+v", left)
+	}
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	a, _ := newTestNode(t, 0)
+	b, _ := newTestNode(t, 1)
+	if err := b.Join(a.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "b to join", func() bool { return len(a.Members()) == 2 })
+
+	// a 错误地怀疑 b，b 应该增加版本号反驳，a 最终重新认为 b 是 alive
+	self := b.LocalMember()
+	a.update(Member{Name: self.Name, State: StateSuspect, Incarnation: self.Incarnation})
+	waitFor(t, "b to refute", func() bool {
+		for _, m := range a.Members() {
+			if m.Name == self.Name {
+				return m.State == StateAlive && m.Incarnation > self.Incarnation
+			}
+		}
+		return false
+	})
+}
+
+func TestJoinNoSeeds(t *testing.T) {
+	m, _ := newTestNode(t, 0)
+	if err := m.Join("127.0.0.1:1"); err == nil {
+		t.Fatal("expected error joining unreachable seed")
+	}
+}
@@ -0,0 +1,69 @@
+package membership
+
+import "strconv"
+
+// State 节点的状态
+type State int
+
+const (
+	StateAlive   State = iota // 正常
+	StateSuspect              // 探测失败，怀疑已经故障，超时后没有被反驳则判定为故障
+	StateDead                 // 已经故障
+	StateLeft                 // 主动离开集群
+)
+
+// String 返回状态的名称
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	default:
+		return "state(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Member 集群中的一个节点
+type Member struct {
+	Name        string // 节点名称，通常是缓存节点的 HTTP 地址，例如 http://localhost:8001，也是一致性哈希环上的节点名
+	Addr        string // 节点之间传递 gossip 消息的 UDP 地址
+	State       State  // 节点的状态
+	Incarnation uint64 // 节点的版本号，只有节点自己可以增加，用来反驳关于自己的过期的怀疑
+}
+
+// down 节点是否已经不在集群中
+func (m *Member) down() bool {
+	return m.State == StateDead || m.State == StateLeft
+}
+
+// apply 按照 SWIM 的规则把 u 合并到已知的节点状态 m 上，返回状态是否发生了变化
+// alive 只有版本号更大时才生效；suspect 可以覆盖同版本的 alive；dead 和 left 可以覆盖同版本的 alive 和 suspect
+func (m *Member) apply(u Member) bool {
+	switch u.State {
+	case StateAlive:
+		if u.Incarnation <= m.Incarnation {
+			return false
+		}
+	case StateSuspect:
+		if m.down() || u.Incarnation < m.Incarnation || (m.State == StateSuspect && u.Incarnation == m.Incarnation) {
+			return false
+		}
+	case StateDead, StateLeft:
+		if m.down() || u.Incarnation < m.Incarnation {
+			return false
+		}
+	default:
+		return false
+	}
+	m.State = u.State
+	m.Incarnation = u.Incarnation
+	if u.Addr != "" {
+		m.Addr = u.Addr
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package distributedCache
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 以 Prometheus 文本格式导出所有 Group 的统计信息，只依赖标准库
+// 可以和 HTTPPool 挂载在同一个监听端口上：
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/_cache/", pool)
+//	mux.Handle("/metrics", distributedCache.MetricsHandler())
+
+const metricsPrefix = "distributed_cache_"
+
+// latencyBuckets 请求远程节点耗时直方图的桶上界，单位秒
+var latencyBuckets = [...]float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram 并发安全的耗时直方图，counts[i] 记录落在第 i 个桶中的次数，最后一个是 +Inf 桶
+type histogram struct {
+	counts [len(latencyBuckets) + 1]AtomicInt
+	sum    AtomicInt // 所有耗时之和，单位纳秒
+	count  AtomicInt
+}
+
+// observe 记录一次耗时
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets[:], seconds)
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+	h.count.Add(1)
+}
+
+// MetricsHandler 返回导出统计信息的 http.Handler，每次请求时遍历全局的 groups
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw, sortedGroups())
+		bw.Flush()
+	})
+}
+
+// sortedGroups 按名称排序返回所有的 Group，保证输出的顺序稳定
+func sortedGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	gs := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i].name < gs[j].name })
+	return gs
+}
+
+// metricWriter 按照 Prometheus 文本格式输出，同一个指标的所有样本写在 HELP 和 TYPE 之后
+type metricWriter struct {
+	w *bufio.Writer
+}
+
+// header 输出指标的 HELP 和 TYPE
+func (mw metricWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// sample 输出一个样本，labels 依次是标签名和标签值
+func (mw metricWriter) sample(name string, value string, labels ...string) {
+	mw.w.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			fmt.Fprintf(mw.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteString(" " + value + "\n")
+}
+
+// escapeLabel 转义标签值中的反斜杠、双引号和换行符
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// groupCounter 描述一个来自 Stats 的计数器
+type groupCounter struct {
+	name, help string
+	value      func(g *Group) int64
+	labels     []string
+}
+
+// groupCounters 所有从 Stats 导出的计数器，同名的计数器通过标签区分
+var groupCounters = []groupCounter{
+	{"gets_total", "Total Get requests, including requests from peers.", func(g *Group) int64 { return g.Stats.Gets.Get() }, nil},
+	{"hits_total", "Cache hits.", func(g *Group) int64 { return g.Stats.CacheHits.Get() }, []string{"cache", "main"}},
+	{"hits_total", "", func(g *Group) int64 { return g.Stats.HotCacheHits.Get() }, []string{"cache", "hot"}},
+	{"hits_total", "", func(g *Group) int64 { return g.Stats.NegativeHits.Get() }, []string{"cache", "negative"}},
+	{"early_refreshes_total", "Background refreshes started before the value expired.", func(g *Group) int64 { return g.Stats.EarlyRefreshes.Get() }, nil},
+	{"stale_total", "Expired values served while revalidating or after a failed load.", func(g *Group) int64 { return g.Stats.StaleHits.Get() }, nil},
+	{"misses_total", "Gets that missed both caches and had to load.", func(g *Group) int64 { return g.Stats.Loads.Get() }, nil},
+	{"loads_deduped_total", "Loads that waited for another caller's in-flight load instead of running their own.", func(g *Group) int64 { return g.Stats.LoadsDeduped.Get() }, nil},
+	{"loads_total", "Successful loads by source.", func(g *Group) int64 { return g.Stats.PeerLoads.Get() }, []string{"source", "peer"}},
+	{"loads_total", "", func(g *Group) int64 { return g.Stats.LocalLoads.Get() }, []string{"source", "local"}},
+	{"loads_total", "", func(g *Group) int64 { return g.Stats.HandoffLoads.Get() }, []string{"source", "handoff"}},
+	{"load_errors_total", "Failed loads by source.", func(g *Group) int64 { return g.Stats.PeerErrors.Get() }, []string{"source", "peer"}},
+	{"load_errors_total", "", func(g *Group) int64 { return g.Stats.LocalLoadErrs.Get() }, []string{"source", "local"}},
+	{"server_requests_total", "Requests received from peers.", func(g *Group) int64 { return g.Stats.ServerRequests.Get() }, nil},
+}
+
+// writeMetrics 输出所有 Group 的指标
+func writeMetrics(w *bufio.Writer, gs []*Group) {
+	mw := metricWriter{w: w}
+	for i, c := range groupCounters {
+		// 同名计数器的 HELP 和 TYPE 只输出一次
+		if i == 0 || groupCounters[i-1].name != c.name {
+			mw.header(c.name, "counter", c.help)
+		}
+		for _, g := range gs {
+			mw.sample(c.name, strconv.FormatInt(c.value(g), 10), append([]string{"group", g.name}, c.labels...)...)
+		}
+	}
+
+	caches := []struct {
+		label string
+		which CacheType
+	}{{"main", MainCache}, {"hot", HotCache}, {"negative", NegativeCache}}
+	stats := make([][]CacheStats, len(gs))
+	for i, g := range gs {
+		for _, c := range caches {
+			stats[i] = append(stats[i], g.CacheStats(c.which))
+		}
+	}
+	cacheMetrics := []struct {
+		name, typ, help string
+		value           func(cs CacheStats) int64
+	}{
+		{"cache_evictions_total", "counter", "Entries evicted or expired from the cache.", func(cs CacheStats) int64 { return cs.Evictions }},
+		{"cache_bytes", "gauge", "Bytes currently used by the cache.", func(cs CacheStats) int64 { return cs.Bytes }},
+		{"cache_items", "gauge", "Entries currently stored in the cache.", func(cs CacheStats) int64 { return cs.Items }},
+	}
+	for _, m := range cacheMetrics {
+		mw.header(m.name, m.typ, m.help)
+		for i, g := range gs {
+			for j, c := range caches {
+				mw.sample(m.name, strconv.FormatInt(m.value(stats[i][j]), 10), "group", g.name, "cache", c.label)
+			}
+		}
+	}
+	mw.header("cache_max_bytes", "gauge", "Configured cacheBytes shared by the main, hot and negative caches, 0 means unlimited.")
+	for _, g := range gs {
+		mw.sample("cache_max_bytes", strconv.FormatInt(g.cacheBytes, 10), "group", g.name)
+	}
+
+	name := "peer_request_duration_seconds"
+	mw.header(name, "histogram", "Latency of requests to peers.")
+	for _, g := range gs {
+		h := &g.peerLatency
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i].Get()
+			mw.sample(name+"_bucket", strconv.FormatInt(cumulative, 10), "group", g.name, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		cumulative += h.counts[len(latencyBuckets)].Get()
+		mw.sample(name+"_bucket", strconv.FormatInt(cumulative, 10), "group", g.name, "le", "+Inf")
+		mw.sample(name+"_sum", strconv.FormatFloat(time.Duration(h.sum.Get()).Seconds(), 'g', -1, 64), "group", g.name)
+		mw.sample(name+"_count", strconv.FormatInt(h.count.Get(), 10), "group", g.name)
+	}
+}
@@ -0,0 +1,44 @@
+package distributedCache
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	g := NewGroup("metrics", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.Get("k")
+	g.Get("k")
+	g.peerLatency.observe(3 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE distributed_cache_gets_total counter",
+		`distributed_cache_gets_total{group="metrics"} 2`,
+		`distributed_cache_hits_total{group="metrics",cache="main"} 1`,
+		`distributed_cache_misses_total{group="metrics"} 1`,
+		`distributed_cache_loads_total{group="metrics",source="local"} 1`,
+		`distributed_cache_cache_bytes{group="metrics",cache="main"} 2`,
+		`distributed_cache_cache_items{group="metrics",cache="main"} 1`,
+		`distributed_cache_cache_max_bytes{group="metrics"} 2048`,
+		"# TYPE distributed_cache_peer_request_duration_seconds histogram",
+		`distributed_cache_peer_request_duration_seconds_bucket{group="metrics",le="0.0025"} 0`,
+		`distributed_cache_peer_request_duration_seconds_bucket{group="metrics",le="0.005"} 1`,
+		`distributed_cache_peer_request_duration_seconds_bucket{group="metrics",le="+Inf"} 1`,
+		`distributed_cache_peer_request_duration_seconds_count{group="metrics"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+	// 同名指标的 HELP 和 TYPE 只能出现一次
+	if n := strings.Count(body, "# TYPE distributed_cache_hits_total "); n != 1 {
+		t.Errorf("TYPE of hits_total written %d times", n)
+	}
+}
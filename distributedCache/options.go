@@ -0,0 +1,197 @@
+package distributedCache
+
+import (
+	"distributedCache/circuitBreaker"
+	"distributedCache/eviction"
+	"time"
+)
+
+// GroupOption 创建 Group 时的可选配置，采用函数式选项的方式，不影响 NewGroup 原有的调用方式
+type GroupOption func(g *Group)
+
+// WithTTL 设置 Group 默认的缓存过期时间，Getter 没有为 key 单独指定过期时间时使用，为 0 表示永不过期
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithSweepInterval 设置后台清理过期缓存的时间间隔
+func WithSweepInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.sweepInterval = interval
+	}
+}
+
+// WithHotCacheRatio 设置热点缓存占用 cacheBytes 的比例，为 0 表示不使用热点缓存
+func WithHotCacheRatio(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.hotCacheRatio = ratio
+	}
+}
+
+// WithNegativeTTL 设置 Getter 返回 ErrNotFound 的 key 缓存的时间，默认为 10s，为 0 表示不缓存
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithNegativeCacheRatio 设置不存在的 key 最多占用 cacheBytes 的比例，默认为 1/16，为 0 表示不缓存
+func WithNegativeCacheRatio(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.negativeRatio = ratio
+	}
+}
+
+// WithStaleWhileRevalidate 缓存值过期之后的 window 时间内，Get 立即返回过期的旧值并在后台刷新一次，
+// 调用者不必等待数据源，返回值的 Stale 为 true
+func WithStaleWhileRevalidate(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleWindow = window
+	}
+}
+
+// WithStaleIfError 缓存值过期之后的 grace 时间内，重新加载失败时继续返回过期的旧值，返回值的 Stale 为 true，
+// 数据源返回 ErrNotFound 时不返回旧值
+func WithStaleIfError(grace time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleGrace = grace
+	}
+}
+
+// WithEarlyRefresh 使用 XFetch 算法在缓存值过期之前提前刷新：命中时以 now - delta * beta * ln(rand) 是否超过过期时间决定
+// 是否在后台刷新，delta 是这个值从数据源加载的耗时。beta 越大越早刷新，1 是通常的取值，为 0 表示不提前刷新
+func WithEarlyRefresh(beta float64) GroupOption {
+	return func(g *Group) {
+		g.earlyBeta = beta
+	}
+}
+
+// WithPolicy 设置 Group 的淘汰策略，mainCache、hotCache 和不存在的 key 使用同一种策略，默认为 LRUPolicy
+func WithPolicy(newPolicy eviction.Factory) GroupOption {
+	return func(g *Group) {
+		g.newPolicy = newPolicy
+	}
+}
+
+// WithShards 设置缓存的分片数，每个分片有独立的锁，分片越多并发读写时的锁竞争越少，默认为 16
+// 每个分片至少分到 64KB 的空间，cacheBytes 较小时实际的分片数会减少
+func WithShards(shards int) GroupOption {
+	return func(g *Group) {
+		g.shards = shards
+	}
+}
+
+// PoolOption 创建 HTTPPool 时的可选配置
+type PoolOption func(p *HTTPPool)
+
+// WithTimeout 设置请求远程节点的默认超时时间，调用者的 ctx 设置了截止时间时以 ctx 为准，为 0 表示不限制
+func WithTimeout(timeout time.Duration) PoolOption {
+	return func(p *HTTPPool) {
+		p.timeout = timeout
+	}
+}
+
+// WithHealthCheck 开启主动健康检查，每隔 interval 请求一次所有远程节点的 /healthz，为 0 表示只根据请求结果被动检查
+func WithHealthCheck(interval time.Duration) PoolOption {
+	return func(p *HTTPPool) {
+		p.healthInterval = interval
+	}
+}
+
+// WithFailureThreshold 设置远程节点连续失败多少次被摘除，默认为 3
+func WithFailureThreshold(n int) PoolOption {
+	return func(p *HTTPPool) {
+		p.failureThreshold = n
+	}
+}
+
+// WithEjectionTime 设置远程节点被摘除多久之后允许再次尝试，默认为 10s
+func WithEjectionTime(d time.Duration) PoolOption {
+	return func(p *HTTPPool) {
+		p.ejectionTime = d
+	}
+}
+
+// WithCircuitBreaker 为每个远程节点创建一个断路器，断路器的名称是节点地址，
+// 断路器打开时请求直接失败，Group 立即回退到本地加载，settings.OnStateChange 可以用来记录日志和告警
+func WithCircuitBreaker(settings circuitBreaker.Settings) PoolOption {
+	return func(p *HTTPPool) {
+		p.breakerSettings = &settings
+	}
+}
+
+// WithHedging 开启对冲请求：key 所属的节点超过最近请求耗时的 percentile 分位数（例如 0.95）还没有应答时，
+// 向哈希环上的下一个节点或者本节点再发一个请求，使用最先返回的结果，对冲请求受重试预算的限制
+func WithHedging(percentile float64) PoolOption {
+	return func(p *HTTPPool) {
+		p.hedgePercentile = percentile
+	}
+}
+
+// WithRetryBudget 开启瞬时错误的重试，换到哈希环上的下一个节点或者本节点重试一次，
+// 重试和对冲请求最多占正常请求的 ratio（例如 0.1），只开启对冲时默认为 0.1
+func WithRetryBudget(ratio float64) PoolOption {
+	return func(p *HTTPPool) {
+		p.retryRatio = ratio
+	}
+}
+
+// WithReplicatedWrites 开启后 Set、Remove 和从数据源加载的缓存值会推送到 key 的所有副本，
+// 需要 RegisterPeers 传入的 PeerPicker 实现 ReplicaPicker，例如使用 WithReplication 的 HTTPPool
+func WithReplicatedWrites() GroupOption {
+	return func(g *Group) {
+		g.replicateWrites = true
+	}
+}
+
+// WithReplication 设置每个 key 保存在哈希环上连续的 n 个节点上，读取时使用第一个健康的副本，默认为 1，
+// n 小于 1 时按 1 处理，否则 PickReplicas 选不到任何节点，写入会被丢弃
+func WithReplication(n int) PoolOption {
+	return func(p *HTTPPool) {
+		if n < 1 {
+			n = 1
+		}
+		p.replicas = n
+	}
+}
+
+// WithPeerWeights 设置节点的权重，权重越大的节点在哈希环上的虚拟节点越多，分到的 key 越多，
+// 例如 64GB 的节点设置为 4，16GB 的节点设置为 1，没有设置的节点权重为 1
+func WithPeerWeights(weights map[string]int) PoolOption {
+	return func(p *HTTPPool) {
+		p.weights = make(map[string]int, len(weights))
+		for addr, weight := range weights {
+			p.weights[addr] = weight
+		}
+	}
+}
+
+// WithBoundedLoad 开启有界负载的一致性哈希，每个节点的容量是平均在途请求数的 (1+epsilon) 倍，
+// key 所属的节点已满时读请求溢出到哈希环上的下一个节点，epsilon 越小负载越均衡，缓存命中率越低，例如 0.25
+func WithBoundedLoad(epsilon float64) PoolOption {
+	return func(p *HTTPPool) {
+		p.loadEpsilon = epsilon
+	}
+}
+
+// WithSelector 设置节点选择策略，可以使用 RingSelector、RendezvousSelector、JumpSelector、MaglevSelector 或者自定义的实现，默认为 RingSelector
+// JumpSelector 按地址排序编号，新节点的地址不是最大的时候移动的 key 远多于其它策略，见 JumpSelector
+func WithSelector(newSelector SelectorFactory) PoolOption {
+	return func(p *HTTPPool) {
+		p.newSelector = newSelector
+	}
+}
+
+// WithHandoff 开启节点变化时的缓存迁移：本节点成为一部分 key 新的 owner 时，在后台从原来的 owner 拉取这些 key 的缓存值，
+// 每秒最多拉取 rate 个 key，迁移完成之前未命中的 key 先向原来的 owner 获取。只支持 RingSelector
+// rate 超过每秒 1e9 时按 1e9 处理，否则限速器的间隔小于 1ns
+func WithHandoff(rate int) PoolOption {
+	return func(p *HTTPPool) {
+		if rate > int(time.Second) {
+			rate = int(time.Second)
+		}
+		p.handoffRate = rate
+	}
+}
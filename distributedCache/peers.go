@@ -1,15 +1,38 @@
 package distributedCache
 
-import "distributedCache/pb"
+import (
+	"context"
+	"distributedCache/pb"
+)
 
 // 抽象两个接口
 
 // PeerPicker 用于根据传入的 key 选择对应的 PeerGetter
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	GetAll() []PeerGetter // 返回除自己以外的所有节点，用于向整个集群广播
 }
 
 // PeerGetter 用于从对应的 group 缓存中查找缓存值，也就是 HTTP 客户端，之前已经实现了提供缓存的服务端
+// ctx 的截止时间会传递给远程节点
 type PeerGetter interface {
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	Set(ctx context.Context, in *pb.SetRequest) error // 把缓存值写入远程节点
+	Remove(ctx context.Context, in *pb.Request) error // 删除远程节点上的缓存值
+}
+
+// ReplicaPicker 可选接口，PeerPicker 同时实现它并且开启了 WithReplicatedWrites 时，Group 把写入推送到 key 的所有副本
+type ReplicaPicker interface {
+	// PickReplicas 返回保存 key 副本的远程节点，self 表示本节点是否也保存副本
+	PickReplicas(key string) (peers []PeerGetter, self bool)
+}
+
+// HandoffPicker 可选接口，节点变化后本节点成为 key 新的 owner，但缓存值还没有迁移过来时，
+// Group 在回退到数据源之前先向 key 原来所属的节点获取
+type HandoffPicker interface {
+	// PickPrevious 返回正在迁移的 key 原来所属的节点，返回的 PeerGetter 只读取对方已经缓存的值，不会触发加载，
+	// 迁移期间被 ForgetPrevious 删除过的 key 返回 false
+	PickPrevious(group, key string) (peer PeerGetter, ok bool)
+	// ForgetPrevious 记下 key 在迁移期间被删除了，原来的 owner 上的旧值不能再被获取或迁移过来
+	ForgetPrevious(group, key string)
 }
@@ -0,0 +1,53 @@
+package distributedCache
+
+import "distributedCache/consistentHash"
+
+// PeerSelector 根据 key 在节点中选择节点的策略，HTTPPool 在持有锁时调用，实现不需要并发安全
+type PeerSelector interface {
+	Add(addrs ...string)
+	Remove(addrs ...string)
+	Get(key string) string                      // 返回 key 所属的节点
+	GetN(key string, n int) []string            // 返回 key 的 n 个不同的节点，第一个就是 Get 返回的节点
+	Walk(key string, fn func(addr string) bool) // 按优先顺序遍历所有不同的节点，fn 返回 false 时停止
+}
+
+// weightedSelector 可选接口，支持节点权重的 PeerSelector 实现它，WithPeerWeights 才会生效
+type weightedSelector interface {
+	AddWeighted(addr string, weight int)
+}
+
+// SelectorFactory 创建一个空的 PeerSelector，HTTPPool.Set 替换全部节点时会创建新的 PeerSelector
+type SelectorFactory func() PeerSelector
+
+// RingSelector 一致性哈希环，每个节点 50 个虚拟节点，也是默认的节点选择策略
+func RingSelector() PeerSelector {
+	return consistentHash.New(defaultReplicas, nil)
+}
+
+// RendezvousSelector rendezvous 哈希，不需要虚拟节点，key 的分布更均匀，节点很多时选择的开销更大
+func RendezvousSelector() PeerSelector {
+	return consistentHash.NewRendezvous(nil)
+}
+
+// JumpSelector jump 一致性哈希，适合按编号寻址、只在末尾增删节点的集群，不支持权重。
+// 编号取决于节点添加的顺序，HTTPPool 总是按地址排序后重建，所有节点因此得到相同的编号。
+// 只有新节点的地址排在最后时才只移动 1/(n+1) 的 key，排在中间时之后的节点编号全部加一，大部分 key 都会移动，
+// 使用时节点地址应该按加入的顺序递增，例如 http://cache-00、http://cache-01
+func JumpSelector() PeerSelector {
+	return consistentHash.NewJump(nil)
+}
+
+// MaglevSelector Maglev 哈希，节点变化时重建大小为 65537 的查找表，选择节点只需要取一次查找表，不支持权重
+func MaglevSelector() PeerSelector {
+	return consistentHash.NewMaglev(0, nil)
+}
+
+// 检查各种策略是否实现了 PeerSelector 的全部接口
+var (
+	_ PeerSelector     = (*consistentHash.Map)(nil)
+	_ PeerSelector     = (*consistentHash.Rendezvous)(nil)
+	_ PeerSelector     = (*consistentHash.Jump)(nil)
+	_ PeerSelector     = (*consistentHash.Maglev)(nil)
+	_ weightedSelector = (*consistentHash.Map)(nil)
+	_ weightedSelector = (*consistentHash.Rendezvous)(nil)
+)
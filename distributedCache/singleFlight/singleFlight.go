@@ -1,16 +1,21 @@
 package singleFlight
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
 
 // call 代表正在进行中，或已经结束的请求
 type call struct {
-	// 并发协程之间如不需要消息传递，非常适合 sync.WaitGroup
-	// 本质是一个内部计数器，在执行goroutine行为之前执行 wg.Add(1)，给计数器+1，
-	// 执行完之后，执行wg.Done()，表示这个goroutine执行完成，计数器内部-1，
-	// wg.Wait()会阻塞代码的运行，等待所有的添加进WaitGroup的goroutine全部执行完毕(计数器减为0)，再退出程序
-	wg  sync.WaitGroup // 使用 sync.WaitGroup 锁避免重入
-	val interface{}    // 保存任意值
-	err error
+	// 请求结束时关闭 done，所有等待的协程都会被唤醒
+	// 相比 sync.WaitGroup，channel 可以和 ctx.Done() 一起 select，等待时能够响应取消和超时
+	done chan struct{}
+	val  interface{} // 保存任意值
+	err  error
+	ctx  *flightContext // 传给 fn 的 ctx，不属于任何一个调用者
 }
 
 // SingleFlight 是 singleflight 的主数据结构，管理不同 key 的请求(call)
@@ -21,37 +26,170 @@ type SingleFlight struct {
 
 // Do 针对相同的 key，无论 Do 被调用多少次，函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误
 func (sf *SingleFlight) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return sf.DoContext(context.Background(), key, fn)
+}
+
+// DoContext 和 Do 一样合并相同 key 的请求，但是每个调用者只等待到自己的 ctx 结束为止
+// fn 在单独的协程中执行，调用者因为取消或超时提前返回时，fn 仍会执行完，结果留给其他仍在等待的调用者
+func (sf *SingleFlight) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	v, err, _ := sf.DoDetached(ctx, key, func(context.Context) (interface{}, error) { return fn() })
+	return v, err
+}
+
+// DoDetached 和 DoContext 一样，fn 收到的 ctx 与调用者的 ctx 分离：第一个调用者取消或超时不会让其他调用者拿到它的错误，
+// fn 的截止时间是所有调用者中最晚的截止时间。只要有一个调用者没有设置截止时间，fn 的 ctx 就不再有截止时间，
+// 即使这个调用者之后取消了也不会恢复，需要限制时长的 fn 应该自己设置超时
+// fn panic 时 panic 会在每个等待的调用者的协程中重新抛出，而不是让执行 fn 的协程结束整个进程
+// shared 表示调用者是否加入了其它调用者已经发起的请求，没有自己调用 fn
+func (sf *SingleFlight) DoDetached(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
 	// g.mu 是保护 Group 的成员变量 m 不被并发读写而加上的锁
 	sf.mu.Lock()
 	// 还没有 key 和 call 的 map, 延迟初始化
 	if sf.m == nil {
 		sf.m = make(map[string]*call)
 	}
-	// 注意 sync.WaitGroup 和 sync.Mutex 的区别
 	// 如果当前的 key 已经存在于 map 中，说明已经有相同的 key 的请求，此时等待请求结束，返回请求的结果，不必再次发起请求
-	if c, ok := sf.m[key]; ok {
-		sf.mu.Unlock()
-		// 如果请求正在进行中，则等待
-		c.wg.Wait()
-		// 请求结束，返回结果
-		return c.val, c.err
-	}
-	// 如果当前的 key 不存在 map 中，说明还没有相同的 key 的请求，需要发起
-	c := new(call)
-	// 发起请求前加锁
-	c.wg.Add(1)
-	// 添加到 g.m，表明 key 已经有对应的请求在处理
-	sf.m[key] = c
+	c, ok := sf.m[key]
+	if !ok {
+		// 如果当前的 key 不存在 map 中，说明还没有相同的 key 的请求，需要发起
+		c = &call{done: make(chan struct{}), ctx: newFlightContext(ctx)}
+		// 添加到 g.m，表明 key 已经有对应的请求在处理
+		sf.m[key] = c
+		go sf.run(c, key, fn)
+	} else {
+		// 后加入的调用者可能有更晚的截止时间
+		c.ctx.join(ctx)
+	}
 	sf.mu.Unlock()
-	// 调用 fn，发起请求
-	c.val, c.err = fn()
-	// 请求结束
-	c.wg.Done()
 
-	// 更新 g.m
-	sf.mu.Lock()
-	delete(sf.m, key)
-	sf.mu.Unlock()
-	// 返回结果
-	return c.val, c.err
+	select {
+	case <-c.done:
+		// 请求结束，返回结果，fn panic 时在调用者的协程中重新 panic
+		if pe, ok := c.err.(*panicError); ok {
+			panic(pe)
+		}
+		return c.val, c.err, ok
+	case <-ctx.Done():
+		return nil, ctx.Err(), ok
+	}
+}
+
+// run 调用 fn 发起请求，结束后唤醒所有等待的调用者并更新 g.m，fn panic 时把 panic 保存为 call 的错误
+func (sf *SingleFlight) run(c *call, key string, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, &panicError{value: r, stack: debug.Stack()}
+		}
+		sf.mu.Lock()
+		delete(sf.m, key)
+		sf.mu.Unlock()
+		c.ctx.end(context.Canceled)
+		close(c.done)
+	}()
+	c.val, c.err = fn(c.ctx)
+}
+
+// panicError fn 中发生的 panic，保留 panic 的值和 fn 所在协程的调用栈
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+// Error 实现 error 接口
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// flightContext 一次请求共用的 ctx，只在截止时间到达或 fn 返回后结束，截止时间随着新的调用者加入向后延长，
+// 加入了没有截止时间的调用者之后不再有截止时间
+type flightContext struct {
+	mu        sync.Mutex
+	done      chan struct{}
+	err       error
+	deadline  time.Time   // 所有调用者中最晚的截止时间
+	unbounded bool        // 有调用者没有设置截止时间，不再限制
+	timer     *time.Timer // 到达 deadline 时结束 ctx
+}
+
+// newFlightContext 以第一个调用者的截止时间创建 flightContext
+func newFlightContext(ctx context.Context) *flightContext {
+	c := &flightContext{done: make(chan struct{})}
+	c.join(ctx)
+	return c
+}
+
+// join 加入一个调用者，它的截止时间更晚或者没有截止时间时延长 c 的截止时间
+func (c *flightContext) join(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil || c.unbounded {
+		return
+	}
+	d, ok := ctx.Deadline()
+	if !ok {
+		c.unbounded = true
+		c.deadline = time.Time{}
+		c.stopTimer()
+		return
+	}
+	if !d.After(c.deadline) {
+		return
+	}
+	c.deadline = d
+	c.stopTimer()
+	c.timer = time.AfterFunc(time.Until(d), c.expire)
+}
+
+// expire 定时器到期，截止时间在此期间被延长时什么也不做
+func (c *flightContext) expire() {
+	c.mu.Lock()
+	expired := !c.unbounded && !time.Now().Before(c.deadline)
+	c.mu.Unlock()
+	if expired {
+		c.end(context.DeadlineExceeded)
+	}
+}
+
+// end 以 err 结束 ctx，只有第一次调用生效
+func (c *flightContext) end(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.stopTimer()
+	close(c.done)
+}
+
+// stopTimer 停止定时器，调用者需要持有 c.mu
+func (c *flightContext) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// Deadline 实现 context.Context，返回所有调用者中最晚的截止时间
+func (c *flightContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline, !c.unbounded
+}
+
+// Done 实现 context.Context
+func (c *flightContext) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 实现 context.Context
+func (c *flightContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Value 实现 context.Context，flightContext 不属于任何一个调用者，不携带调用者的值
+func (c *flightContext) Value(key interface{}) interface{} {
+	return nil
 }
@@ -0,0 +1,152 @@
+package singleFlight
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var sf SingleFlight
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := sf.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "value", nil
+			})
+			if err != nil || v.(string) != "value" {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("fn called %d times, expect 1", calls)
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var sf SingleFlight
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// fn 一直阻塞时，调用者在超时后返回
+	_, err := sf.DoContext(ctx, "key", func() (interface{}, error) {
+		<-release
+		return "value", nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var sf SingleFlight
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+	// fn 的 panic 在每个等待的调用者中重新抛出，不会结束整个进程
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				r := recover()
+				if err, ok := r.(error); !ok || !strings.HasPrefix(err.Error(), "boom") {
+					t.Errorf("expect panic boom, got %v", r)
+				}
+			}()
+			sf.Do("key", fn)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// panic 之后 key 被清理，新的调用重新执行 fn
+	if v, err := sf.Do("key", func() (interface{}, error) { return "value", nil }); err != nil || v != "value" {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
+
+func TestDoDetached(t *testing.T) {
+	var sf SingleFlight
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		select {
+		case <-release:
+			return "value", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	// 第一个调用者很快超时，第二个调用者没有截止时间，fn 不应该因为第一个调用者而结束
+	leader, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		_, err, shared := sf.DoDetached(leader, "key", fn)
+		if shared {
+			t.Errorf("leader should not be shared")
+		}
+		errc <- err
+	}()
+	<-started
+	result := make(chan interface{}, 1)
+	go func() {
+		v, err, shared := sf.DoDetached(context.Background(), "key", fn)
+		if err != nil || !shared {
+			t.Errorf("waiter got %v, shared = %v", err, shared)
+		}
+		result <- v
+	}()
+	if err := <-errc; err != context.DeadlineExceeded {
+		t.Fatalf("leader got %v, expect DeadlineExceeded", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if v := <-result; v != "value" {
+		t.Fatalf("waiter got %v", v)
+	}
+}
+
+func TestDoDetachedDeadline(t *testing.T) {
+	var sf SingleFlight
+	started := make(chan struct{})
+	deadlines := make(chan time.Time, 1)
+	first, cancel1 := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel1()
+	go sf.DoDetached(first, "key", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		d, _ := ctx.Deadline()
+		deadlines <- d
+		return nil, ctx.Err()
+	})
+	<-started
+	// fn 的截止时间延长到最晚的调用者，到期后所有调用者都收到 DeadlineExceeded
+	second, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	if _, err, _ := sf.DoDetached(second, "key", nil); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+	if d, _ := second.Deadline(); !(<-deadlines).Equal(d) {
+		t.Fatal("flight deadline is not the latest caller deadline")
+	}
+}
@@ -0,0 +1,76 @@
+package distributedCache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// 统计 Group 和 cache 的运行状态，替代命中时打印日志的方式，方便汇总和监控
+
+// AtomicInt 并发安全的 int64 计数器
+type AtomicInt int64
+
+// Add 原子地加上 n
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取当前值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+// String 返回当前值的字符串形式
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats Group 的统计信息，所有字段都是原子计数器
+type Stats struct {
+	Gets           AtomicInt // Get 请求的次数，包括来自远程节点的请求
+	CacheHits      AtomicInt // mainCache 命中的次数
+	HotCacheHits   AtomicInt // hotCache 命中的次数
+	NegativeHits   AtomicInt // 命中不存在的 key 的记录，直接返回 ErrNotFound 的次数
+	StaleHits      AtomicInt // 返回已经过期的旧值的次数，包括后台刷新期间和重新加载失败之后
+	EarlyRefreshes AtomicInt // 缓存值过期之前被提前在后台刷新的次数
+	Loads          AtomicInt // 缓存未命中需要加载的次数
+	LoadsDeduped   AtomicInt // 被 singleFlight 合并、等待其它调用者的加载结果而没有自己加载的次数
+	PeerLoads      AtomicInt // 从远程节点加载成功的次数
+	PeerErrors     AtomicInt // 从远程节点加载失败的次数
+	LocalLoads     AtomicInt // 从数据源加载成功的次数
+	LocalLoadErrs  AtomicInt // 从数据源加载失败的次数
+	HandoffLoads   AtomicInt // 节点变化后的迁移期间，从 key 原来所属的节点获取成功的次数
+	ServerRequests AtomicInt // HTTPPool 收到的来自远程节点的请求次数
+}
+
+// CacheType 表示 Group 中的哪一个缓存
+type CacheType int
+
+const (
+	MainCache     CacheType = iota + 1 // 保存本节点负责的 key 的缓存
+	HotCache                           // 保存从远程节点获取的热点 key 的缓存
+	NegativeCache                      // 保存数据源中不存在的 key 的缓存
+)
+
+// CacheStats 某一个缓存在某一时刻的统计信息快照
+type CacheStats struct {
+	Bytes     int64 // 已使用的内存
+	Items     int64 // 保存的缓存值数量
+	Gets      int64 // 查找的次数
+	Hits      int64 // 命中的次数
+	Evictions int64 // 被淘汰或过期移除的次数，不包括主动删除
+}
+
+// CacheStats 返回指定缓存的统计信息快照
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	case NegativeCache:
+		return g.negCache.stats()
+	default:
+		return CacheStats{}
+	}
+}
@@ -0,0 +1,86 @@
+package tinyLFU
+
+// 实现 Count-Min Sketch 频率过滤器，用很小的内存近似统计每个 key 的访问频率
+
+const (
+	sketchDepth = 4  // 哈希函数的个数，也就是计数器的行数
+	maxCounter  = 15 // 每个计数器最多计数到 15，和 4 bit 计数器一致，频率更高也没有区分的意义
+)
+
+// sketchSeeds 每一行使用不同的种子，得到相互独立的下标
+var sketchSeeds = [sketchDepth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+// cmSketch Count-Min Sketch，记录次数达到 sampleSize 时所有计数器减半（老化），让过去的热点逐渐冷却
+type cmSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64 // 每一行的长度是 2 的幂，下标通过与运算得到
+	additions  int    // 上次老化之后记录的次数
+	sampleSize int    // 触发老化的记录次数
+}
+
+// newCmSketch 创建一个每行至少 width 个计数器的 sketch
+func newCmSketch(width int) *cmSketch {
+	n := 1
+	for n < width {
+		n <<= 1
+	}
+	s := &cmSketch{mask: uint64(n - 1), sampleSize: 10 * n}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, n)
+	}
+	return s
+}
+
+// index 计算 key 的哈希值 h 在第 i 行的下标
+func (s *cmSketch) index(h uint64, i int) uint64 {
+	x := (h ^ sketchSeeds[i]) * 0x9e3779b97f4a7c15
+	x ^= x >> 32
+	return x & s.mask
+}
+
+// increment 记录一次 key 的访问
+func (s *cmSketch) increment(key string) {
+	h := hashKey(key)
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < maxCounter {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate 估算 key 的访问频率，取所有行中的最小值
+func (s *cmSketch) estimate(key string) uint8 {
+	h := hashKey(key)
+	min := uint8(maxCounter)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset 老化：所有计数器减半
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// hashKey 使用 fnv-1a 计算 key 的 64 位哈希值，直接展开计算避免每次分配内存
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -0,0 +1,272 @@
+package tinyLFU
+
+import (
+	"container/list"
+	"distributedCache/eviction"
+	"time"
+)
+
+// 实现 W-TinyLFU 淘汰策略
+// 新节点先进入一个很小的 LRU 窗口区，从窗口区淘汰的节点作为候选者，只有访问频率高于主区的淘汰者才会被主区接纳，
+// 主区是分段 LRU (SLRU)：试用区 probation 和保护区 protected，试用区的节点再次被访问后晋升到保护区。
+// 访问频率由 Count-Min Sketch 近似统计并定期老化，这样一次性的扫描请求不会把整个缓存冲刷掉。
+
+const (
+	windowRatio    = 0.01 // 窗口区占总内存的比例
+	protectedRatio = 0.8  // 保护区占主区的比例
+	avgEntryBytes  = 64   // 估算节点数量时假设的平均节点大小，用于确定 sketch 的大小
+	minSketchWidth = 1 << 10
+	maxSketchWidth = 1 << 20
+)
+
+// segment 节点所在的区域
+type segment int
+
+const (
+	window segment = iota
+	probation
+	protected
+)
+
+// CacheTinyLFU W-TinyLFU 缓存，并发访问是不安全的。
+type CacheTinyLFU struct {
+	maxBytes     int64                                  // 允许使用的最大内存，为 0 表示不限制
+	windowMax    int64                                  // 窗口区允许使用的最大内存
+	protectedMax int64                                  // 保护区允许使用的最大内存
+	nbytes       int64                                  // 当前已使用的内存
+	bytes        [3]int64                               // 每个区域已使用的内存
+	lists        [3]*list.List                          // 每个区域一个双向链表，约定 Back 为队尾，Front 为最先被淘汰的队首
+	cacheMap     map[string]*list.Element               // 键是字符串，值是链表中对应节点的指针
+	sketch       *cmSketch                              // 访问频率过滤器
+	OnEvicted    func(key string, value eviction.Value) // 某条记录被移除时的回调函数
+}
+
+// node 链表节点的数据类型
+type node struct {
+	key    string
+	value  eviction.Value
+	expire time.Time // 过期时间，零值表示永不过期
+	seg    segment   // 节点所在的区域
+}
+
+// expired 判断节点在 now 时刻是否已经过期
+func (n *node) expired(now time.Time) bool {
+	return !n.expire.IsZero() && now.After(n.expire)
+}
+
+// size 节点占用的内存
+func (n *node) size() int64 {
+	return int64(len(n.key)) + int64(n.value.Len())
+}
+
+// New 实例化一个 CacheTinyLFU
+func New(maxBytes int64, onEvicted func(key string, value eviction.Value)) *CacheTinyLFU {
+	windowMax := int64(float64(maxBytes) * windowRatio)
+	width := int(maxBytes / avgEntryBytes)
+	if width < minSketchWidth {
+		width = minSketchWidth
+	}
+	if width > maxSketchWidth {
+		width = maxSketchWidth
+	}
+	c := &CacheTinyLFU{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		protectedMax: int64(float64(maxBytes-windowMax) * protectedRatio),
+		cacheMap:     make(map[string]*list.Element),
+		sketch:       newCmSketch(width),
+		OnEvicted:    onEvicted,
+	}
+	for i := range c.lists {
+		c.lists[i] = list.New()
+	}
+	return c
+}
+
+// Find 查找节点并记录一次访问，过期的节点视为未命中并顺便删除
+func (c *CacheTinyLFU) Find(key string) (value eviction.Value, ok bool) {
+	// 未命中的访问同样计入频率，候选者被再次请求时更容易被主区接纳
+	c.sketch.increment(key)
+	elem, ok := c.cacheMap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*node)
+	if kv.expired(time.Now()) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.touch(elem)
+	return kv.value, true
+}
+
+// Peek 查找节点，不记录访问，不改变节点所在的区域和位置，过期的节点视为未命中但不删除
+func (c *CacheTinyLFU) Peek(key string) (value eviction.Value, ok bool) {
+	elem, ok := c.cacheMap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*node)
+	if kv.expired(time.Now()) {
+		return nil, false
+	}
+	return kv.value, true
+}
+
+// Add 新增一个永不过期的节点
+func (c *CacheTinyLFU) Add(key string, value eviction.Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增一个节点，新节点先放入窗口区，expire 为零值表示永不过期
+func (c *CacheTinyLFU) AddWithExpire(key string, value eviction.Value, expire time.Time) {
+	c.sketch.increment(key)
+	if elem, ok := c.cacheMap[key]; ok {
+		kv := elem.Value.(*node)
+		delta := int64(value.Len()) - int64(kv.value.Len())
+		c.nbytes += delta
+		c.bytes[kv.seg] += delta
+		kv.value = value
+		kv.expire = expire
+		c.touch(elem)
+	} else {
+		kv := &node{key: key, value: value, expire: expire, seg: window}
+		c.cacheMap[key] = c.lists[window].PushBack(kv)
+		c.nbytes += kv.size()
+		c.bytes[window] += kv.size()
+	}
+	c.evict()
+}
+
+// touch 节点被访问：窗口区和保护区的节点移动到队尾，试用区的节点晋升到保护区
+func (c *CacheTinyLFU) touch(elem *list.Element) {
+	kv := elem.Value.(*node)
+	if kv.seg != probation {
+		c.lists[kv.seg].MoveToBack(elem)
+		return
+	}
+	c.move(elem, protected)
+	// 保护区超出容量时，把保护区队首的节点降级到试用区
+	for c.maxBytes != 0 && c.bytes[protected] > c.protectedMax {
+		c.move(c.lists[protected].Front(), probation)
+	}
+}
+
+// move 把节点移动到另一个区域的队尾
+func (c *CacheTinyLFU) move(elem *list.Element, seg segment) *list.Element {
+	kv := elem.Value.(*node)
+	c.lists[kv.seg].Remove(elem)
+	c.bytes[kv.seg] -= kv.size()
+	kv.seg = seg
+	c.bytes[seg] += kv.size()
+	newElem := c.lists[seg].PushBack(kv)
+	c.cacheMap[kv.key] = newElem
+	return newElem
+}
+
+// evict 窗口区溢出的节点作为候选者进入试用区，主区超出容量时候选者与淘汰者比较访问频率，频率低的被淘汰
+func (c *CacheTinyLFU) evict() {
+	if c.maxBytes == 0 {
+		return
+	}
+	mainMax := c.maxBytes - c.windowMax
+	for c.bytes[window] > c.windowMax {
+		candidate := c.move(c.lists[window].Front(), probation)
+		for c.bytes[probation]+c.bytes[protected] > mainMax {
+			victim := c.victim(candidate)
+			// 访问频率相同时拒绝候选者，保护主区中已有的节点
+			if victim == nil || c.sketch.estimate(candidate.Value.(*node).key) <= c.sketch.estimate(victim.Value.(*node).key) {
+				c.removeElement(candidate)
+				break
+			}
+			c.removeElement(victim)
+		}
+	}
+	// 已有节点的值变大时也可能超出容量
+	for c.nbytes > c.maxBytes {
+		c.Remove()
+	}
+}
+
+// victim 主区中最先被淘汰的节点，优先选择试用区的队首，不会选中候选者自己
+func (c *CacheTinyLFU) victim(candidate *list.Element) *list.Element {
+	if elem := c.lists[probation].Front(); elem != nil && elem != candidate {
+		return elem
+	}
+	return c.lists[protected].Front()
+}
+
+// Remove 按照策略淘汰一个节点，依次从试用区、窗口区、保护区的队首淘汰
+func (c *CacheTinyLFU) Remove() {
+	for _, seg := range []segment{probation, window, protected} {
+		if elem := c.lists[seg].Front(); elem != nil {
+			c.removeElement(elem)
+			return
+		}
+	}
+}
+
+// Delete 删除指定 key 的节点，返回节点是否存在
+func (c *CacheTinyLFU) Delete(key string) bool {
+	if elem, ok := c.cacheMap[key]; ok {
+		c.removeElement(elem)
+		return true
+	}
+	return false
+}
+
+// RemoveExpired 移除所有已经过期的节点，返回移除的数量
+func (c *CacheTinyLFU) RemoveExpired() int {
+	now := time.Now()
+	count := 0
+	for _, l := range c.lists {
+		for elem := l.Front(); elem != nil; {
+			next := elem.Next()
+			if elem.Value.(*node).expired(now) {
+				c.removeElement(elem)
+				count++
+			}
+			elem = next
+		}
+	}
+	return count
+}
+
+// removeElement 从链表和 map 中删除节点，更新内存使用并调用回调函数
+func (c *CacheTinyLFU) removeElement(elem *list.Element) {
+	kv := elem.Value.(*node)
+	c.lists[kv.seg].Remove(elem)
+	delete(c.cacheMap, kv.key)
+	c.nbytes -= kv.size()
+	c.bytes[kv.seg] -= kv.size()
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// GetRecord 用于获取添加了多少条数据
+func (c *CacheTinyLFU) GetRecord() int {
+	return len(c.cacheMap)
+}
+
+// Keys 返回所有没有过期的节点的 key，不移动节点，也不记录访问频率
+func (c *CacheTinyLFU) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, len(c.cacheMap))
+	for _, l := range c.lists {
+		for elem := l.Front(); elem != nil; elem = elem.Next() {
+			if kv := elem.Value.(*node); !kv.expired(now) {
+				keys = append(keys, kv.key)
+			}
+		}
+	}
+	return keys
+}
+
+// Bytes 返回当前已使用的内存
+func (c *CacheTinyLFU) Bytes() int64 {
+	return c.nbytes
+}
+
+// 检查 CacheTinyLFU 是否实现了 eviction.Policy 的全部接口
+var _ eviction.Policy = (*CacheTinyLFU)(nil)
@@ -0,0 +1,181 @@
+package tinyLFU
+
+import (
+	"distributedCache/eviction"
+	"distributedCache/lru"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestCacheTinyLFU_Find(t *testing.T) {
+	c := New(int64(0), nil)
+	c.Add("key1", String("1234"))
+	if v, ok := c.Find("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Find("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestCacheTinyLFU_Peek(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("key1", String("1234"))
+	freq, seg := c.sketch.estimate("key1"), c.cacheMap["key1"].Value.(*node).seg
+	// Peek 不记录访问频率，也不改变节点所在的区域
+	for i := 0; i < 5; i++ {
+		if v, ok := c.Peek("key1"); !ok || string(v.(String)) != "1234" {
+			t.Fatalf("peek key1 failed")
+		}
+		c.Peek("key2")
+	}
+	if c.sketch.estimate("key1") != freq || c.sketch.estimate("key2") != 0 {
+		t.Fatalf("peek changed the frequency sketch")
+	}
+	if now := c.cacheMap["key1"].Value.(*node).seg; now != seg {
+		t.Fatalf("peek moved key1 from segment %d to %d", seg, now)
+	}
+}
+
+func TestCacheTinyLFU_Admission(t *testing.T) {
+	// 每个节点占 4 字节，主区可以放下大约 24 个节点
+	c := New(int64(100), nil)
+	hot := make([]string, 10)
+	for i := range hot {
+		hot[i] = "h" + strconv.Itoa(i)
+		c.Add(hot[i], String("v"))
+	}
+	// 热点 key 被多次访问
+	for n := 0; n < 5; n++ {
+		for _, key := range hot {
+			c.Find(key)
+		}
+	}
+	// 一次性扫描大量只访问一次的 key
+	for i := 0; i < 1000; i++ {
+		key := "s" + strconv.Itoa(i)
+		if _, ok := c.Find(key); !ok {
+			c.Add(key, String("v"))
+		}
+	}
+	for _, key := range hot {
+		if _, ok := c.Find(key); !ok {
+			t.Fatalf("hot key %s flushed by scan", key)
+		}
+	}
+	if c.Bytes() > 100 {
+		t.Fatalf("bytes %d exceed maxBytes", c.Bytes())
+	}
+}
+
+func TestCacheTinyLFU_OnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	c := New(int64(10), func(key string, value eviction.Value) {
+		keys = append(keys, key)
+	})
+	c.Add("key1", String("123456"))
+	c.Add("k2", String("k2"))
+	// key1 和 k2 的访问频率相同，新来的候选者 k2 被拒绝
+	expect := []string{"k2"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+func TestCacheTinyLFU_Expire(t *testing.T) {
+	c := New(int64(0), nil)
+	c.AddWithExpire("k1", String("v1"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k2", String("v2"), time.Now().Add(-time.Second))
+	c.AddWithExpire("k3", String("v3"), time.Now().Add(time.Hour))
+	if _, ok := c.Find("k1"); ok {
+		t.Fatalf("expired k1 should be a miss")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.GetRecord() != 1 {
+		t.Fatalf("RemoveExpired removed %d, expect 1", n)
+	}
+}
+
+func TestCmSketch(t *testing.T) {
+	s := newCmSketch(16)
+	for i := 0; i < 6; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if s.estimate("hot") < 6 || s.estimate("hot") <= s.estimate("cold") {
+		t.Fatalf("estimate hot=%d cold=%d", s.estimate("hot"), s.estimate("cold"))
+	}
+	// 老化之后计数减半
+	s.reset()
+	if s.estimate("hot") != 3 {
+		t.Fatalf("estimate after reset = %d, expect 3", s.estimate("hot"))
+	}
+}
+
+// zipfTrace 服从 Zipf 分布的请求序列，少量热点 key 占据了大部分请求
+func zipfTrace(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.1, 1, 100000)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = strconv.FormatUint(z.Uint64(), 10)
+	}
+	return trace
+}
+
+// scanTrace 在 Zipf 分布的请求中周期性地插入一次性的顺序扫描，模拟批处理任务
+func scanTrace(n int) []string {
+	zipf := zipfTrace(n)
+	trace := make([]string, 0, n)
+	scan := 0
+	for i := 0; len(trace) < n; i++ {
+		if i%10000 < 3000 {
+			trace = append(trace, "scan"+strconv.Itoa(scan))
+			scan++
+			continue
+		}
+		trace = append(trace, zipf[i%len(zipf)])
+	}
+	return trace
+}
+
+// benchmarkHitRatio 按照请求序列访问缓存，未命中时加入缓存，报告命中率
+func benchmarkHitRatio(b *testing.B, policy eviction.Policy, trace []string) {
+	hits, total := 0, 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := trace[i%len(trace)]
+		total++
+		if _, ok := policy.Find(key); ok {
+			hits++
+			continue
+		}
+		policy.Add(key, String("value"))
+	}
+	b.ReportMetric(float64(hits)*100/float64(total), "hit%")
+}
+
+func BenchmarkHitRatio(b *testing.B) {
+	const maxBytes = 1000 * 10
+	traces := map[string][]string{
+		"Zipf": zipfTrace(1 << 18),
+		"Scan": scanTrace(1 << 18),
+	}
+	for _, name := range []string{"Zipf", "Scan"} {
+		trace := traces[name]
+		b.Run(name+"/CacheLRU", func(b *testing.B) {
+			benchmarkHitRatio(b, lru.New(maxBytes, nil), trace)
+		})
+		b.Run(name+"/CacheTinyLFU", func(b *testing.B) {
+			benchmarkHitRatio(b, New(maxBytes, nil), trace)
+		})
+	}
+}
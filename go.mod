@@ -4,4 +4,6 @@ go 1.18
 
 require distributedCache v0.0.0
 
+require google.golang.org/protobuf v1.30.0 // indirect
+
 replace distributedCache => ./distributedCache
@@ -2,10 +2,14 @@ package main
 
 import (
 	"distributedCache"
+	"distributedCache/circuitBreaker"
+	"distributedCache/membership"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -22,20 +26,52 @@ func createGroup() *distributedCache.Group {
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			// 不存在的 key 返回 ErrNotFound，Group 会短暂缓存这个结果，避免反复查询数据库
+			return nil, fmt.Errorf("%s not exist: %w", key, distributedCache.ErrNotFound)
+		}),
+		// 过期之后 10s 内立即返回旧值并在后台刷新，数据库故障时旧值最多再使用 5 分钟，
+		// 快要过期时按照查询数据库的耗时提前在后台刷新
+		distributedCache.WithTTL(time.Minute),
+		distributedCache.WithStaleWhileRevalidate(10*time.Second),
+		distributedCache.WithStaleIfError(5*time.Minute),
+		distributedCache.WithEarlyRefresh(1))
 }
 
 // 用来启动缓存服务器：创建 HTTPPool，添加节点信息，注册到 distributedCache 中，启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知。
-func startCacheServer(addr string, addrs []string, cacheGroup *distributedCache.Group) {
+// gossip 不为空时通过 gossip 协议发现其它节点，节点加入、离开或故障时自动更新哈希环，
+// 节点变化后新的 owner 从原来的 owner 迁移分到的 key，否则使用固定的 addrs
+func startCacheServer(addr string, addrs []string, gossip string, seeds []string, cacheGroup *distributedCache.Group) {
 	// addr 是当前端口号对应的计算机节点的 URL, addrs 是所有计算机节点的 URL
 	//log.Printf("main.go: startCacheServer -> addr = %s, addrs = %v\n", addr, addrs)
-	peers := distributedCache.NewHTTPPool(addr)
-	peers.Set(addrs...)
-	// 注册所有的 计算机节点
+	peers := distributedCache.NewHTTPPool(addr,
+		distributedCache.WithHealthCheck(5*time.Second),
+		distributedCache.WithHedging(0.95),
+		distributedCache.WithRetryBudget(0.1),
+		distributedCache.WithHandoff(1000),
+		distributedCache.WithCircuitBreaker(circuitBreaker.Settings{
+			OnStateChange: func(name string, from, to circuitBreaker.State) {
+				log.Printf("circuit breaker of %s: %s -> %s", name, from, to)
+			},
+		}))
+	// 注册所有的 计算机节点，要在节点变化之前注册，迁移时才能找到使用这个 HTTPPool 的 Group
 	cacheGroup.RegisterPeers(peers)
+	if gossip == "" {
+		peers.Set(addrs...)
+	} else {
+		list, err := membership.Create(membership.Config{Name: addr, BindAddr: gossip, Peers: peers})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := list.Join(seeds...); err != nil {
+			log.Println("join cluster:", err)
+		}
+	}
+	// 节点间通信和监控指标挂载在同一个端口上
+	mux := http.NewServeMux()
+	mux.Handle("/_cache/", peers)
+	mux.Handle("/metrics", distributedCache.MetricsHandler())
 	log.Println("distributedCache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
 }
 
 // 用来启动一个 API 服务（端口 9999），与用户进行交互，用户感知
@@ -44,11 +80,19 @@ func startAPIServer(apiAddr string, cacheGroup *distributedCache.Group) {
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
 			//  根据 key 来查找
-			view, err := cacheGroup.Get(key)
+			view, err := cacheGroup.GetContext(r.Context(), key)
+			if errors.Is(err, distributedCache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			// 过期的旧值按照 HTTP 的约定加上 Warning 头，调用者可以区分
+			if view.Stale() {
+				w.Header().Set("Warning", `110 - "Response is Stale"`)
+			}
 			w.Header().Set("Content-Type", "application/octet-stream")
 			w.Write(view.ByteSlice())
 
@@ -61,8 +105,11 @@ func startAPIServer(apiAddr string, cacheGroup *distributedCache.Group) {
 func main() {
 	var port int
 	var api bool
+	var gossip, seeds string
 	flag.IntVar(&port, "port", 8001, "distributedCache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&gossip, "gossip", "", "gossip UDP address, e.g. localhost:7001; empty uses the static peer list")
+	flag.StringVar(&seeds, "seeds", "", "comma separated gossip addresses of nodes to join")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -81,5 +128,9 @@ func main() {
 		go startAPIServer(apiAddr, cache)
 	}
 	time.Sleep(time.Second)
-	startCacheServer(addrMap[port], []string(addrs), cache)
+	var seedList []string
+	if seeds != "" {
+		seedList = strings.Split(seeds, ",")
+	}
+	startCacheServer(addrMap[port], []string(addrs), gossip, seedList, cache)
 }